@@ -3,7 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/conductorone/baton-pagerduty/pkg/connector"
 	"github.com/conductorone/baton-sdk/pkg/cli"
 	"github.com/spf13/cobra"
 )
@@ -12,13 +18,146 @@ import (
 type config struct {
 	cli.BaseConfig `mapstructure:",squash"` // Puts the base config options in the same place as the connector options
 
-	AccessToken string `mapstructure:"token"`
+	AccessToken           string        `mapstructure:"token"`
+	PagerDutyClientID     string        `mapstructure:"pagerduty-client-id"`
+	PagerDutyClientSecret string        `mapstructure:"pagerduty-client-secret"`
+	Subdomain             string        `mapstructure:"subdomain"`
+	Scopes                []string      `mapstructure:"scopes"`
+	Provisioning          bool          `mapstructure:"provisioning"`
+	Region                string        `mapstructure:"region"`
+	APIEndpoint           string        `mapstructure:"api-endpoint"`
+	ScheduleLayer         string        `mapstructure:"schedule-layer"`
+	OverrideDuration      time.Duration `mapstructure:"on-call-override-duration"`
+	OnCallLookahead       string        `mapstructure:"on-call-lookahead"`
+	LicenseUpgrade        bool          `mapstructure:"license-upgrade"`
+	AccountTemplate       string        `mapstructure:"account-template"`
+	TeamReassignment      string        `mapstructure:"team-reassignment"`
+	RevokeFallbackRole    string        `mapstructure:"revoke-fallback-role"`
+	LicenseFallbackRoles  []string      `mapstructure:"revoke-fallback-license-roles"`
+
+	// the base config doesn't carry these on-demand provisioning flags of the SDK
+	CreateAccountLogin string `mapstructure:"create-account-login"`
+	DeleteResourceID   string `mapstructure:"delete-resource"`
+}
+
+// regionEndpoints maps the PagerDuty service regions to their REST API endpoint.
+var regionEndpoints = map[string]string{
+	"us": "https://api.pagerduty.com",
+	"eu": "https://api.eu.pagerduty.com",
+}
+
+// apiEndpoint returns the REST API endpoint to connect to, a custom endpoint takes precedence over the region.
+func apiEndpoint(cfg *config) string {
+	if cfg.APIEndpoint != "" {
+		return strings.TrimSuffix(cfg.APIEndpoint, "/")
+	}
+
+	return regionEndpoints[strings.ToLower(cfg.Region)]
+}
+
+// provisioningEnabled reports whether the connector may run provisioning actions, either as a service or on demand.
+func provisioningEnabled(cfg *config) bool {
+	return cfg.Provisioning ||
+		cfg.GrantEntitlementID != "" ||
+		cfg.RevokeGrantID != "" ||
+		cfg.CreateAccountLogin != "" ||
+		cfg.DeleteResourceID != ""
+}
+
+// parseLookahead parses a duration that, on top of the standard Go units, accepts whole days (e.g. 7d).
+func parseLookahead(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q: %w", s, err)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// parseLicenseFallbackRoles parses the license_id=role pairs mapping licenses to their fallback role.
+func parseLicenseFallbackRoles(pairs []string) (map[string]string, error) {
+	rv := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		licenseID, role, ok := strings.Cut(pair, "=")
+		if !ok || licenseID == "" || role == "" {
+			return nil, fmt.Errorf("invalid license fallback role %q, must be license_id=role", pair)
+		}
+
+		rv[strings.TrimSpace(licenseID)] = strings.TrimSpace(role)
+	}
+
+	return rv, nil
 }
 
 // validateConfig is run after the configuration is loaded, and should return an error if it isn't valid.
 func validateConfig(ctx context.Context, cfg *config) error {
-	if cfg.AccessToken == "" {
-		return fmt.Errorf("access token is missing")
+	if cfg.AccessToken == "" && cfg.PagerDutyClientID == "" {
+		return fmt.Errorf("access token or PagerDuty OAuth app credentials are missing")
+	}
+
+	if cfg.AccessToken != "" && cfg.PagerDutyClientID != "" {
+		return fmt.Errorf("only one of access token or PagerDuty OAuth app credentials can be set")
+	}
+
+	if cfg.PagerDutyClientID != "" {
+		if cfg.PagerDutyClientSecret == "" {
+			return fmt.Errorf("PagerDuty OAuth app client secret is missing")
+		}
+
+		if cfg.Subdomain == "" {
+			return fmt.Errorf("subdomain of the PagerDuty account is required with OAuth app credentials")
+		}
+	}
+
+	if _, ok := regionEndpoints[strings.ToLower(cfg.Region)]; !ok {
+		return fmt.Errorf("region %q is invalid, must be one of: us, eu", cfg.Region)
+	}
+
+	if cfg.APIEndpoint != "" {
+		u, err := url.Parse(cfg.APIEndpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("api endpoint must be an absolute https URL")
+		}
+	}
+
+	if cfg.ScheduleLayer != "" {
+		if _, err := regexp.Compile(cfg.ScheduleLayer); err != nil {
+			return fmt.Errorf("schedule layer pattern is invalid: %w", err)
+		}
+	}
+
+	if cfg.OverrideDuration <= 0 {
+		return fmt.Errorf("on-call override duration must be positive")
+	}
+
+	// revoking a role must leave the user with a base role it can be given, licenses are checked on revocation
+	if !connector.CreatableRole(cfg.RevokeFallbackRole) {
+		return fmt.Errorf("revoke fallback role %q is invalid, must be a PagerDuty base role other than owner", cfg.RevokeFallbackRole)
+	}
+
+	licenseFallbackRoles, err := parseLicenseFallbackRoles(cfg.LicenseFallbackRoles)
+	if err != nil {
+		return err
+	}
+
+	for licenseID, role := range licenseFallbackRoles {
+		if !connector.CreatableRole(role) {
+			return fmt.Errorf("revoke fallback role %q of license %s is invalid, must be a PagerDuty base role other than owner", role, licenseID)
+		}
+	}
+
+	lookahead, err := parseLookahead(cfg.OnCallLookahead)
+	if err != nil {
+		return fmt.Errorf("on-call lookahead is invalid: %w", err)
+	}
+
+	// PagerDuty only lists on-call shifts up to 90 days ahead
+	if lookahead < 0 || lookahead > 90*24*time.Hour {
+		return fmt.Errorf("on-call lookahead must be between 0 and 90 days")
 	}
 
 	return nil
@@ -27,4 +166,18 @@ func validateConfig(ctx context.Context, cfg *config) error {
 // cmdFlags sets the cmdFlags required for the connector.
 func cmdFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String("token", "", "The PagerDuty access token used to connect to the PagerDuty API. ($BATON_TOKEN)")
+	cmd.PersistentFlags().String("pagerduty-client-id", "", "The client ID of a scoped PagerDuty OAuth app, used instead of the access token. ($BATON_PAGERDUTY_CLIENT_ID)")
+	cmd.PersistentFlags().String("pagerduty-client-secret", "", "The client secret of the scoped PagerDuty OAuth app. ($BATON_PAGERDUTY_CLIENT_SECRET)")
+	cmd.PersistentFlags().String("subdomain", "", "The subdomain of the PagerDuty account the OAuth app is installed in. ($BATON_SUBDOMAIN)")
+	cmd.PersistentFlags().StringSlice("scopes", nil, "The scopes requested for the OAuth app, defaults to the scopes needed to sync and, with provisioning, to provision. ($BATON_SCOPES)")
+	cmd.PersistentFlags().String("region", "us", "The PagerDuty service region of the account: us or eu. ($BATON_REGION)")
+	cmd.PersistentFlags().String("api-endpoint", "", "Custom PagerDuty REST API endpoint, overrides the region endpoint. ($BATON_API_ENDPOINT)")
+	cmd.PersistentFlags().String("schedule-layer", "", "Regular expression matching the name of the schedule layer users are added to, defaults to the last layer. ($BATON_SCHEDULE_LAYER)")
+	cmd.PersistentFlags().Duration("on-call-override-duration", time.Hour, "Default duration of the schedule override created when granting schedule on-call. ($BATON_ON_CALL_OVERRIDE_DURATION)")
+	cmd.PersistentFlags().String("account-template", "", "Path to a JSON file with the contact methods and notification rules added to created users. ($BATON_ACCOUNT_TEMPLATE)")
+	cmd.PersistentFlags().String("team-reassignment", "", "ID of the team receiving the escalation policies, schedules and services of deleted teams. ($BATON_TEAM_REASSIGNMENT)")
+	cmd.PersistentFlags().String("revoke-fallback-role", "limited_user", "The base role given to users whose role is revoked. ($BATON_REVOKE_FALLBACK_ROLE)")
+	cmd.PersistentFlags().StringSlice("revoke-fallback-license-roles", nil, "The fallback role of users holding a license, as license_id=role pairs, overriding the fallback role. ($BATON_REVOKE_FALLBACK_LICENSE_ROLES)")
+	cmd.PersistentFlags().Bool("license-upgrade", false, "Move users to a license allowing the granted role when their license doesn't allow it. ($BATON_LICENSE_UPGRADE)")
+	cmd.PersistentFlags().String("on-call-lookahead", "1h", "How far ahead on-call shifts are synced, e.g. 0h, 24h or 7d. ($BATON_ON_CALL_LOOKAHEAD)")
 }
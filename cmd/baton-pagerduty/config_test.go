@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidateConfigFallbackRoles(t *testing.T) {
+	tests := []struct {
+		name                 string
+		fallbackRole         string
+		licenseFallbackRoles []string
+		wantErr              bool
+	}{
+		{name: "default", fallbackRole: "limited_user"},
+		{name: "license roles", fallbackRole: "limited_user", licenseFallbackRoles: []string{"PXXXXXX=read_only_user"}},
+		{name: "owner", fallbackRole: "owner", wantErr: true},
+		{name: "team role", fallbackRole: "responder", wantErr: true},
+		{name: "license owner", fallbackRole: "limited_user", licenseFallbackRoles: []string{"PXXXXXX=owner"}, wantErr: true},
+		{name: "license pair", fallbackRole: "limited_user", licenseFallbackRoles: []string{"read_only_user"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config{
+				AccessToken:          "token",
+				Region:               "us",
+				OverrideDuration:     time.Hour,
+				OnCallLookahead:      "1h",
+				RevokeFallbackRole:   tt.fallbackRole,
+				LicenseFallbackRoles: tt.licenseFallbackRoles,
+			}
+
+			err := validateConfig(context.Background(), cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/conductorone/baton-pagerduty/pkg/connector"
 	"github.com/conductorone/baton-sdk/pkg/cli"
@@ -37,7 +38,44 @@ func main() {
 
 func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, error) {
 	l := ctxzap.Extract(ctx)
-	pagerDutyConnector, err := connector.New(ctx, cfg.AccessToken)
+
+	onCallLookahead, err := parseLookahead(cfg.OnCallLookahead)
+	if err != nil {
+		l.Error("error parsing on-call lookahead", zap.Error(err))
+		return nil, err
+	}
+
+	licenseFallbackRoles, err := parseLicenseFallbackRoles(cfg.LicenseFallbackRoles)
+	if err != nil {
+		l.Error("error parsing license fallback roles", zap.Error(err))
+		return nil, err
+	}
+
+	var oauthApp *connector.OAuthApp
+	if cfg.PagerDutyClientID != "" {
+		oauthApp = &connector.OAuthApp{
+			ClientID:     cfg.PagerDutyClientID,
+			ClientSecret: cfg.PagerDutyClientSecret,
+			Subdomain:    cfg.Subdomain,
+			Region:       strings.ToLower(cfg.Region),
+			Scopes:       cfg.Scopes,
+			Provisioning: provisioningEnabled(cfg),
+		}
+	}
+
+	pagerDutyConnector, err := connector.New(ctx, connector.Config{
+		AccessToken:          cfg.AccessToken,
+		OAuthApp:             oauthApp,
+		APIEndpoint:          apiEndpoint(cfg),
+		ScheduleLayer:        cfg.ScheduleLayer,
+		OverrideDuration:     cfg.OverrideDuration,
+		OnCallLookahead:      onCallLookahead,
+		LicenseUpgrade:       cfg.LicenseUpgrade,
+		AccountTemplatePath:  cfg.AccountTemplate,
+		TeamReassignment:     cfg.TeamReassignment,
+		FallbackRole:         cfg.RevokeFallbackRole,
+		LicenseFallbackRoles: licenseFallbackRoles,
+	})
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
@@ -0,0 +1,66 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// defaultContactMethodLabel is the label of the email contact method PagerDuty creates along with a user.
+const defaultContactMethodLabel = "Default"
+
+// accountTemplate describes the contact methods and notification rules added to the users created by the connector.
+type accountTemplate struct {
+	ContactMethods    []contactMethodTemplate    `json:"contact_methods"`
+	NotificationRules []notificationRuleTemplate `json:"notification_rules"`
+}
+
+type contactMethodTemplate struct {
+	// Type is the PagerDuty contact method type, e.g. phone_contact_method or sms_contact_method
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	// AddressField is the field of the account profile holding the address, e.g. phone
+	AddressField string `json:"address_field"`
+	CountryCode  int    `json:"country_code"`
+}
+
+type notificationRuleTemplate struct {
+	// ContactMethod is the label of the contact method to notify, "Default" being the login email
+	ContactMethod       string `json:"contact_method"`
+	Urgency             string `json:"urgency"`
+	StartDelayInMinutes uint   `json:"start_delay_in_minutes"`
+}
+
+// loadAccountTemplate reads the account template from a JSON file, an empty path means no template.
+func loadAccountTemplate(path string) (*accountTemplate, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to read account template: %w", err)
+	}
+
+	var rv accountTemplate
+	if err := json.Unmarshal(data, &rv); err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: invalid account template: %w", err)
+	}
+
+	labels := map[string]bool{defaultContactMethodLabel: true}
+	for _, cm := range rv.ContactMethods {
+		if cm.Type == "" || cm.Label == "" || cm.AddressField == "" {
+			return nil, fmt.Errorf("pagerduty-connector: invalid account template: contact methods need a type, label and address_field")
+		}
+
+		labels[cm.Label] = true
+	}
+
+	for _, rule := range rv.NotificationRules {
+		if !labels[rule.ContactMethod] {
+			return nil, fmt.Errorf("pagerduty-connector: invalid account template: notification rule uses unknown contact method %q", rule.ContactMethod)
+		}
+	}
+
+	return &rv, nil
+}
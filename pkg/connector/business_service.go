@@ -0,0 +1,215 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+const (
+	businessServiceOwner       = "owner"
+	businessServiceStakeholder = "stakeholder"
+
+	// supporting services of a business service are either business services or technical services
+	dependencyTypeBusinessService = "business_service"
+)
+
+type businessServiceResourceType struct {
+	resourceType *v2.ResourceType
+	client       *pagerduty.Client
+	apiEndpoint  string
+}
+
+func (b *businessServiceResourceType) ResourceType(_ context.Context) *v2.ResourceType {
+	return b.resourceType
+}
+
+// businessServiceResource creates a new connector resource for a PagerDuty Business Service.
+func businessServiceResource(service *pagerduty.BusinessService) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"business_service_id":   service.ID,
+		"business_service_name": service.Name,
+		"point_of_contact":      service.PointOfContact,
+	}
+
+	if service.Team != nil {
+		profile["business_service_team"] = service.Team.ID
+	}
+
+	resource, err := rs.NewGroupResource(
+		service.Name,
+		resourceTypeBusinessService,
+		service.ID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription(service.Description),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (b *businessServiceResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	bag, cursor, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeBusinessService.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	businessServicesResponse, err := b.listBusinessServices(ctx, cursor.Offset)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list business services: %w", err)
+	}
+
+	rv := make([]*v2.Resource, 0, len(businessServicesResponse.BusinessServices))
+	for _, service := range businessServicesResponse.BusinessServices {
+		br, err := businessServiceResource(service)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, br)
+	}
+
+	// business services can't be filtered by query, so the collection can't be partitioned
+	pageToken, err := handleNextPage(bag, cursor, listedPage{More: businessServicesResponse.More}, false)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, pageToken, rl.annotations(), nil
+}
+
+// listBusinessServices lists a single page of business services.
+// go-pagerduty only exposes auto-paginated listing of business services, so the page is fetched directly.
+func (b *businessServiceResourceType) listBusinessServices(ctx context.Context, offset uint) (*pagerduty.ListBusinessServicesResponse, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(ResourcesPageSize))
+	query.Set("offset", strconv.FormatUint(uint64(offset), 10))
+
+	u := fmt.Sprintf("%s/business_services?%s", b.apiEndpoint, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv pagerduty.ListBusinessServicesResponse
+	err = doAPIRequest(b.client, req, &rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rv, nil
+}
+
+func (b *businessServiceResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	ownerEntitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeTeam),
+		ent.WithDisplayName(fmt.Sprintf("%s business service %s", resource.DisplayName, businessServiceOwner)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty business service %s", resource.DisplayName, businessServiceOwner)),
+	}
+
+	stakeholderEntitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeUser, resourceTypeService, resourceTypeBusinessService),
+		ent.WithDisplayName(fmt.Sprintf("%s business service %s", resource.DisplayName, businessServiceStakeholder)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty business service %s", resource.DisplayName, businessServiceStakeholder)),
+	}
+
+	rv = append(
+		rv,
+		ent.NewAssignmentEntitlement(resource, businessServiceOwner, ownerEntitlementOptions...),
+		ent.NewAssignmentEntitlement(resource, businessServiceStakeholder, stakeholderEntitlementOptions...),
+	)
+
+	return rv, "", nil, nil
+}
+
+func (b *businessServiceResourceType) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	groupTrait, err := rs.GetGroupTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	if teamID, ok := rs.GetProfileStringValue(groupTrait.Profile, "business_service_team"); ok && teamID != "" {
+		rv = append(rv, grant.NewGrant(
+			resource,
+			businessServiceOwner,
+			&v2.ResourceId{
+				ResourceType: resourceTypeTeam.Id,
+				Resource:     teamID,
+			},
+			grant.WithAnnotation(
+				&v2.GrantExpandable{
+					EntitlementIds: []string{fmt.Sprintf("team:%s:%s", teamID, roleMember)},
+				},
+			),
+		))
+	}
+
+	dependencies, err := b.client.ListBusinessServiceDependenciesWithContext(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list business service dependencies: %w", err)
+	}
+
+	// stakeholders are reached through the responders of every supporting service
+	for _, dependency := range dependencies.Relationships {
+		if dependency.DependentService == nil || dependency.DependentService.ID != resource.Id.Resource {
+			continue
+		}
+
+		supporting := dependency.SupportingService
+		if supporting == nil {
+			continue
+		}
+
+		principal := &v2.ResourceId{
+			ResourceType: resourceTypeService.Id,
+			Resource:     supporting.ID,
+		}
+		entitlementID := fmt.Sprintf("service:%s:%s", supporting.ID, serviceResponder)
+
+		if strings.HasPrefix(supporting.Type, dependencyTypeBusinessService) {
+			principal.ResourceType = resourceTypeBusinessService.Id
+			entitlementID = fmt.Sprintf("business_service:%s:%s", supporting.ID, businessServiceStakeholder)
+		}
+
+		rv = append(rv, grant.NewGrant(
+			resource,
+			businessServiceStakeholder,
+			principal,
+			grant.WithAnnotation(
+				&v2.GrantExpandable{
+					EntitlementIds: []string{entitlementID},
+				},
+			),
+		))
+	}
+
+	return rv, "", rl.annotations(), nil
+}
+
+func businessServiceBuilder(client *pagerduty.Client, apiEndpoint string) *businessServiceResourceType {
+	return &businessServiceResourceType{
+		resourceType: resourceTypeBusinessService,
+		client:       client,
+		apiEndpoint:  apiEndpoint,
+	}
+}
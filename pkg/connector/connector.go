@@ -2,11 +2,19 @@ package connector
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"golang.org/x/oauth2"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -14,6 +22,8 @@ import (
 const (
 	abilityTeams = "teams"
 
+	userAgent = "baton-pagerduty"
+
 	roleMember    = "member"
 	roleObserver  = "observer"
 	roleResponder = "responder"
@@ -43,6 +53,13 @@ var (
 			v2.ResourceType_TRAIT_ROLE,
 		},
 	}
+	resourceTypeLicense = &v2.ResourceType{
+		Id:          "license",
+		DisplayName: "License",
+		Traits: []v2.ResourceType_Trait{
+			v2.ResourceType_TRAIT_ROLE,
+		},
+	}
 	resourceTypeSchedule = &v2.ResourceType{
 		Id:          "schedule",
 		DisplayName: "Schedule",
@@ -50,18 +67,66 @@ var (
 			v2.ResourceType_TRAIT_GROUP,
 		},
 	}
+	resourceTypeScheduleLayer = &v2.ResourceType{
+		Id:          "schedule_layer",
+		DisplayName: "Schedule Layer",
+		Traits: []v2.ResourceType_Trait{
+			v2.ResourceType_TRAIT_GROUP,
+		},
+	}
+	resourceTypeEscalationPolicy = &v2.ResourceType{
+		Id:          "escalation_policy",
+		DisplayName: "Escalation Policy",
+		Traits: []v2.ResourceType_Trait{
+			v2.ResourceType_TRAIT_GROUP,
+		},
+	}
+	resourceTypeService = &v2.ResourceType{
+		Id:          "service",
+		DisplayName: "Service",
+		Traits: []v2.ResourceType_Trait{
+			v2.ResourceType_TRAIT_GROUP,
+		},
+	}
+	resourceTypeBusinessService = &v2.ResourceType{
+		Id:          "business_service",
+		DisplayName: "Business Service",
+		Traits: []v2.ResourceType_Trait{
+			v2.ResourceType_TRAIT_GROUP,
+		},
+	}
 )
 
 type PagerDuty struct {
-	client *pagerduty.Client
+	client           *pagerduty.Client
+	apiEndpoint      string
+	oauthApp         *OAuthApp
+	tokenSource      oauth2.TokenSource
+	users            *userCache
+	licenses         *licenseAllocations
+	overrides        *createdOverrides
+	layerPattern     *regexp.Regexp
+	overrideDuration time.Duration
+	onCallLookahead  time.Duration
+	licenseUpgrade   bool
+	accountTemplate  *accountTemplate
+	teamReassignment string
+	// fallbackRole and licenseFallbackRoles are the base roles given to users whose role is revoked
+	fallbackRole         string
+	licenseFallbackRoles map[string]string
 }
 
 func (pd *PagerDuty) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
 	return []connectorbuilder.ResourceSyncer{
-		teamBuilder(pd.client),
-		userBuilder(pd.client),
-		roleBuilder(pd.client),
-		scheduleBuilder(pd.client),
+		teamBuilder(pd.client, pd.apiEndpoint, pd.teamReassignment),
+		userBuilder(pd.client, pd.apiEndpoint, pd.users, pd.licenses, pd.accountTemplate),
+		roleBuilder(pd.client, pd.apiEndpoint, pd.users, pd.licenseUpgrade, pd.fallbackRole, pd.licenseFallbackRoles),
+		licenseBuilder(pd.client, pd.apiEndpoint, pd.users, pd.licenses),
+		scheduleBuilder(pd.client, pd.layerPattern, pd.overrideDuration, pd.onCallLookahead, pd.overrides),
+		scheduleLayerBuilder(pd.client),
+		escalationPolicyBuilder(pd.client),
+		serviceBuilder(pd.client),
+		businessServiceBuilder(pd.client, pd.apiEndpoint),
 	}
 }
 
@@ -69,16 +134,22 @@ func (pd *PagerDuty) ResourceSyncers(ctx context.Context) []connectorbuilder.Res
 func (pd *PagerDuty) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
 	return &v2.ConnectorMetadata{
 		DisplayName: "PagerDuty",
-		Description: "Connector syncing PagerDuty users, teams, and their roles to Baton",
+		Description: "Connector syncing PagerDuty users, teams, licenses, schedules, escalation policies, services, business services and their roles to Baton",
 	}, nil
 }
 
 // Validate hits the PagerDuty API to validate that the configured credentials are valid and compatible.
 func (pd *PagerDuty) Validate(ctx context.Context) (annotations.Annotations, error) {
+	if pd.oauthApp != nil {
+		if err := pd.oauthApp.validateScopes(pd.tokenSource); err != nil {
+			return nil, err
+		}
+	}
+
 	// should be able to list users
 	_, err := pd.client.ListUsersWithContext(ctx, pagerduty.ListUsersOptions{})
 	if err != nil {
-		return nil, status.Error(codes.Unauthenticated, "Provided Access Token is invalid")
+		return nil, validationError(pd.apiEndpoint, err)
 	}
 
 	// in case it's a user token, check the role for compatibility
@@ -90,12 +161,129 @@ func (pd *PagerDuty) Validate(ctx context.Context) (annotations.Annotations, err
 	return nil, nil
 }
 
+// validationError maps the error of the validation request to the status reported to the user.
+func validationError(apiEndpoint string, err error) error {
+	var apiErr pagerduty.APIError
+	if !errors.As(err, &apiErr) {
+		return status.Errorf(codes.Unavailable, "failed to reach the PagerDuty API at %s: %v", apiEndpoint, err)
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized:
+		// accounts only exist in a single service region, a token is rejected by the API of the other region
+		return status.Errorf(
+			codes.Unauthenticated,
+			"Provided Access Token is invalid for %s, check that it belongs to an account in this service region",
+			apiEndpoint,
+		)
+	case http.StatusForbidden:
+		return status.Errorf(codes.PermissionDenied, "Provided Access Token isn't allowed to list users: %v", err)
+	case http.StatusTooManyRequests:
+		return status.Errorf(codes.ResourceExhausted, "PagerDuty API rate limit reached at %s, retry later: %v", apiEndpoint, err)
+	}
+
+	// the API answered, but not with something the connector can work with
+	if apiErr.StatusCode >= http.StatusInternalServerError {
+		return status.Errorf(codes.Unavailable, "PagerDuty API at %s failed: %v", apiEndpoint, err)
+	}
+
+	return status.Errorf(codes.FailedPrecondition, "PagerDuty API at %s rejected the validation request: %v", apiEndpoint, err)
+}
+
+// userAgentTransport drops the user agent set by go-pagerduty, so that the uhttp transport sets the connector one.
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Del("User-Agent")
+
+	return t.base.RoundTrip(req)
+}
+
+// Config is the configuration of the PagerDuty connector.
+type Config struct {
+	// AccessToken is used as a REST API key, unless an OAuth app is set
+	AccessToken string
+	OAuthApp    *OAuthApp
+	APIEndpoint string
+	// ScheduleLayer is a pattern matching the name of the schedule layer users are added to
+	ScheduleLayer string
+	// OverrideDuration is the default duration of the overrides created by on-call grants
+	OverrideDuration time.Duration
+	OnCallLookahead  time.Duration
+	LicenseUpgrade   bool
+	// AccountTemplatePath is the JSON file with the contact methods and notification rules of created users
+	AccountTemplatePath string
+	TeamReassignment    string
+	// FallbackRole and LicenseFallbackRoles are the base roles given to users whose role is revoked,
+	// they must be roles CreatableRole accepts
+	FallbackRole         string
+	LicenseFallbackRoles map[string]string
+}
+
 // New returns the PagerDuty connector.
-func New(ctx context.Context, accessToken string) (*PagerDuty, error) {
-	client := pagerduty.NewClient(accessToken)
+func New(ctx context.Context, cfg Config) (*PagerDuty, error) {
+	httpClient, err := uhttp.NewClient(
+		ctx,
+		uhttp.WithLogger(true, ctxzap.Extract(ctx)),
+		uhttp.WithUserAgent(userAgent),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to create http client: %w", err)
+	}
+
+	options := []pagerduty.ClientOptions{pagerduty.WithAPIEndpoint(cfg.APIEndpoint)}
+	transport := httpClient.Transport
+
+	var tokenSource oauth2.TokenSource
+	if cfg.OAuthApp != nil {
+		// the oauth2 transport sets the access token on every request, refreshing it when needed
+		tokenSource = cfg.OAuthApp.tokenSource(ctx, httpClient)
+		transport = &oauth2.Transport{
+			Source: tokenSource,
+			Base:   transport,
+		}
+		options = append(options, pagerduty.WithOAuth())
+	}
+
+	client := pagerduty.NewClient(cfg.AccessToken, options...)
+	// retries wrap the uhttp transport, so every attempt goes through the proxy, TLS and logging settings
+	client.HTTPClient = &http.Client{
+		Transport: newRateLimitTransport(&userAgentTransport{base: transport}),
+	}
 
 	pd := &PagerDuty{
-		client: client,
+		client:           client,
+		apiEndpoint:      cfg.APIEndpoint,
+		oauthApp:         cfg.OAuthApp,
+		tokenSource:      tokenSource,
+		users:            newUserCache(client),
+		licenses:         newLicenseAllocations(client, cfg.APIEndpoint),
+		overrides:        newCreatedOverrides(),
+		overrideDuration: cfg.OverrideDuration,
+		onCallLookahead:  cfg.OnCallLookahead,
+		licenseUpgrade:   cfg.LicenseUpgrade,
+		teamReassignment: cfg.TeamReassignment,
+
+		fallbackRole:         cfg.FallbackRole,
+		licenseFallbackRoles: cfg.LicenseFallbackRoles,
+	}
+
+	pd.accountTemplate, err = loadAccountTemplate(cfg.AccountTemplatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ScheduleLayer != "" {
+		layerPattern, err := regexp.Compile(cfg.ScheduleLayer)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: invalid schedule layer pattern: %w", err)
+		}
+
+		pd.layerPattern = layerPattern
 	}
 
 	return pd, nil
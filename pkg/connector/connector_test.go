@@ -0,0 +1,34 @@
+package connector
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/PagerDuty/go-pagerduty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidationError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{name: "transport", err: errors.New("dial tcp: connection refused"), want: codes.Unavailable},
+		{name: "unauthorized", err: pagerduty.APIError{StatusCode: http.StatusUnauthorized}, want: codes.Unauthenticated},
+		{name: "forbidden", err: pagerduty.APIError{StatusCode: http.StatusForbidden}, want: codes.PermissionDenied},
+		{name: "throttled", err: pagerduty.APIError{StatusCode: http.StatusTooManyRequests}, want: codes.ResourceExhausted},
+		{name: "server error", err: pagerduty.APIError{StatusCode: http.StatusServiceUnavailable}, want: codes.Unavailable},
+		{name: "bad request", err: pagerduty.APIError{StatusCode: http.StatusBadRequest}, want: codes.FailedPrecondition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(validationError("https://api.pagerduty.com", tt.err)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
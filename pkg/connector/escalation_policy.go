@@ -0,0 +1,437 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	escalationTargetUser           = "user_reference"
+	escalationTargetSchedule       = "schedule_reference"
+	escalationLevelEntitlementFmt  = "level-%d-target"
+	escalationOnCallEntitlementFmt = "level-%d-on-call"
+)
+
+type escalationPolicyResourceType struct {
+	resourceType *v2.ResourceType
+	client       *pagerduty.Client
+	onCalls      *onCallSnapshot
+}
+
+func (e *escalationPolicyResourceType) ResourceType(_ context.Context) *v2.ResourceType {
+	return e.resourceType
+}
+
+// escalationLevelEntitlement returns the entitlement slug for the given (1-based) escalation rule level.
+func escalationLevelEntitlement(level int) string {
+	return fmt.Sprintf(escalationLevelEntitlementFmt, level)
+}
+
+// escalationOnCallEntitlement returns the entitlement slug of users on-call at the given (1-based) escalation level.
+func escalationOnCallEntitlement(level int) string {
+	return fmt.Sprintf(escalationOnCallEntitlementFmt, level)
+}
+
+// parseEscalationLevel returns the (1-based) escalation rule level encoded in the entitlement slug.
+func parseEscalationLevel(slug string) (int, error) {
+	var level int
+	_, err := fmt.Sscanf(slug, escalationLevelEntitlementFmt, &level)
+	// Sscanf ignores whatever follows the level, which would take on-call slugs for level slugs
+	if err != nil || level < 1 || slug != escalationLevelEntitlement(level) {
+		return 0, fmt.Errorf("pagerduty-connector: invalid escalation level entitlement %s", slug)
+	}
+
+	return level, nil
+}
+
+// isEscalationOnCallEntitlement reports whether the slug is one of the per-level on-call entitlements.
+func isEscalationOnCallEntitlement(slug string) bool {
+	var level int
+	_, err := fmt.Sscanf(slug, escalationOnCallEntitlementFmt, &level)
+	return err == nil && slug == escalationOnCallEntitlement(level)
+}
+
+// escalationTargetType maps a principal to the escalation rule target type referencing it.
+func escalationTargetType(principal *v2.ResourceId) (string, bool) {
+	switch principal.ResourceType {
+	case resourceTypeUser.Id:
+		return escalationTargetUser, true
+	case resourceTypeSchedule.Id:
+		return escalationTargetSchedule, true
+	default:
+		return "", false
+	}
+}
+
+// escalationPolicyResource creates a new connector resource for a PagerDuty Escalation Policy.
+func escalationPolicyResource(policy *pagerduty.EscalationPolicy) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"escalation_policy_id":   policy.ID,
+		"escalation_policy_name": policy.Name,
+		"escalation_levels":      len(policy.EscalationRules),
+		"num_loops":              int(policy.NumLoops),
+	}
+
+	resource, err := rs.NewGroupResource(
+		policy.Name,
+		resourceTypeEscalationPolicy,
+		policy.ID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription(policy.Description),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (e *escalationPolicyResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	// a new sync starts from the first page, on-call grants must come from a fresh snapshot
+	if pt.Token == "" {
+		e.onCalls.reset()
+	}
+
+	bag, cursor, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeEscalationPolicy.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	paginationOpts := pagerduty.ListEscalationPoliciesOptions{
+		Limit:  ResourcesPageSize,
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
+	}
+
+	policiesResponse, err := e.client.ListEscalationPoliciesWithContext(ctx, paginationOpts)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list escalation policies: %w", err)
+	}
+
+	rv := make([]*v2.Resource, 0, len(policiesResponse.EscalationPolicies))
+	for _, policy := range policiesResponse.EscalationPolicies {
+		pr, err := escalationPolicyResource(&policy) // #nosec G601
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, pr)
+	}
+
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  policiesResponse.More,
+		Total: policiesResponse.Total,
+		Names: listedNames(policiesResponse.EscalationPolicies, func(policy pagerduty.EscalationPolicy) string { return policy.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, pageToken, rl.annotations(), nil
+}
+
+func (e *escalationPolicyResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	groupTrait, err := rs.GetGroupTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	levels, ok := rs.GetProfileInt64Value(groupTrait.Profile, "escalation_levels")
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	// Create new target and on-call entitlements for each escalation rule level
+	rv := make([]*v2.Entitlement, 0, 2*levels)
+	for level := 1; level <= int(levels); level++ {
+		rv = append(
+			rv,
+			ent.NewAssignmentEntitlement(
+				resource,
+				escalationLevelEntitlement(level),
+				[]ent.EntitlementOption{
+					ent.WithGrantableTo(resourceTypeUser, resourceTypeSchedule),
+					ent.WithDisplayName(fmt.Sprintf("%s Escalation Level %d Target", resource.DisplayName, level)),
+					ent.WithDescription(fmt.Sprintf("Target of escalation level %d in %s PagerDuty escalation policy", level, resource.DisplayName)),
+				}...,
+			),
+			ent.NewAssignmentEntitlement(
+				resource,
+				escalationOnCallEntitlement(level),
+				[]ent.EntitlementOption{
+					ent.WithDisplayName(fmt.Sprintf("%s On-Call at Escalation Level %d", resource.DisplayName, level)),
+					ent.WithDescription(fmt.Sprintf("Currently on-call at escalation level %d in %s PagerDuty escalation policy", level, resource.DisplayName)),
+				}...,
+			),
+		)
+	}
+
+	return rv, "", nil, nil
+}
+
+func (e *escalationPolicyResourceType) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	policy, err := e.client.GetEscalationPolicyWithContext(ctx, resource.Id.Resource, &pagerduty.GetEscalationPolicyOptions{})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to get escalation policy: %w", err)
+	}
+
+	var rv []*v2.Grant
+	for i, rule := range policy.EscalationRules {
+		level := escalationLevelEntitlement(i + 1)
+
+		for _, target := range rule.Targets {
+			switch target.Type {
+			case escalationTargetUser:
+				rv = append(rv, grant.NewGrant(
+					resource,
+					level,
+					&v2.ResourceId{
+						ResourceType: resourceTypeUser.Id,
+						Resource:     target.ID,
+					},
+				))
+
+			case escalationTargetSchedule:
+				// users of the schedule are paged through this level, so expand into schedule membership
+				rv = append(rv, grant.NewGrant(
+					resource,
+					level,
+					&v2.ResourceId{
+						ResourceType: resourceTypeSchedule.Id,
+						Resource:     target.ID,
+					},
+					grant.WithAnnotation(
+						&v2.GrantExpandable{
+							EntitlementIds: []string{fmt.Sprintf("schedule:%s:%s", target.ID, scheduleMember)},
+						},
+					),
+				))
+			}
+		}
+	}
+
+	onCallGrants, err := e.onCallGrants(ctx, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rv = append(rv, onCallGrants...)
+
+	return rv, "", rl.annotations(), nil
+}
+
+// onCallGrants grants the per-level on-call entitlements to everyone currently on-call for the escalation policy.
+func (e *escalationPolicyResourceType) onCallGrants(ctx context.Context, resource *v2.Resource) ([]*v2.Grant, error) {
+	onCalls, err := e.onCalls.forEscalationPolicy(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	type onCallKey struct {
+		userID string
+		level  uint
+	}
+
+	// a user can be on-call at the same level through several schedules, report them on a single grant
+	var keys []onCallKey
+	shifts := make(map[onCallKey][]pagerduty.OnCall)
+	for _, onCall := range onCalls {
+		key := onCallKey{userID: onCall.User.ID, level: onCall.EscalationLevel}
+		if _, ok := shifts[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		shifts[key] = append(shifts[key], onCall)
+	}
+
+	rv := make([]*v2.Grant, 0, len(keys))
+	for _, key := range keys {
+		var schedules []interface{}
+		for _, onCall := range shifts[key] {
+			// users targeted directly by the escalation rule are on-call without a schedule
+			if onCall.Schedule.ID != "" {
+				schedules = append(schedules, onCall.Schedule.ID)
+			}
+		}
+
+		first := shifts[key][0]
+		rv = append(rv, grant.NewGrant(
+			resource,
+			escalationOnCallEntitlement(int(key.level)),
+			&v2.ResourceId{
+				ResourceType: resourceTypeUser.Id,
+				Resource:     key.userID,
+			},
+			grant.WithGrantMetadata(map[string]interface{}{
+				"schedule_ids": schedules,
+				"shift_start":  first.Start,
+				"shift_end":    first.End,
+			}),
+		))
+	}
+
+	return rv, nil
+}
+
+// getEscalationRule fetches the escalation policy together with the index of the rule for the given level.
+func (e *escalationPolicyResourceType) getEscalationRule(ctx context.Context, policyID string, slug string) (*pagerduty.EscalationPolicy, int, error) {
+	if isEscalationOnCallEntitlement(slug) {
+		return nil, 0, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: %s is derived from the targets of the escalation level and their schedules, "+
+				"grant or revoke the escalation level or the schedule on-call instead",
+			slug,
+		)
+	}
+
+	level, err := parseEscalationLevel(slug)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	policy, err := e.client.GetEscalationPolicyWithContext(ctx, policyID, &pagerduty.GetEscalationPolicyOptions{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("pagerduty-connector: failed to get escalation policy: %w", err)
+	}
+
+	if level > len(policy.EscalationRules) {
+		return nil, 0, status.Errorf(codes.NotFound, "pagerduty-connector: escalation policy %s has no level %d", policyID, level)
+	}
+
+	return policy, level - 1, nil
+}
+
+func (e *escalationPolicyResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	targetType, ok := escalationTargetType(principal.Id)
+	if !ok {
+		l.Warn(
+			"pagerduty-connector: only users and schedules can be granted escalation level",
+			zap.String("principal_id", principal.Id.Resource),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, fmt.Errorf("pagerduty-connector: only users and schedules can be granted escalation level")
+	}
+
+	policy, idx, err := e.getEscalationRule(ctx, entitlement.Resource.Id.Resource, entitlement.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &policy.EscalationRules[idx]
+	for _, target := range rule.Targets {
+		if target.ID == principal.Id.Resource && target.Type == targetType {
+			l.Info(
+				"pagerduty-connector: principal is already a target of escalation level",
+				zap.String("principal_id", principal.Id.Resource),
+				zap.String("entitlement_id", entitlement.Id),
+			)
+
+			return nil, nil
+		}
+	}
+
+	rule.Targets = append(rule.Targets, pagerduty.APIObject{
+		ID:   principal.Id.Resource,
+		Type: targetType,
+	})
+
+	// grant escalation level
+	_, err = e.client.UpdateEscalationPolicyWithContext(ctx, policy.ID, *policy)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to grant escalation level: %w", err)
+	}
+
+	return nil, nil
+}
+
+func (e *escalationPolicyResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	entitlement := grant.Entitlement
+	principal := grant.Principal
+
+	targetType, ok := escalationTargetType(principal.Id)
+	if !ok {
+		l.Warn(
+			"pagerduty-connector: only users and schedules can have escalation level revoked",
+			zap.String("principal_id", principal.Id.Resource),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, fmt.Errorf("pagerduty-connector: only users and schedules can have escalation level revoked")
+	}
+
+	policy, idx, err := e.getEscalationRule(ctx, entitlement.Resource.Id.Resource, entitlement.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &policy.EscalationRules[idx]
+	targets := make([]pagerduty.APIObject, 0, len(rule.Targets))
+	for _, target := range rule.Targets {
+		if target.ID == principal.Id.Resource && target.Type == targetType {
+			continue
+		}
+
+		targets = append(targets, target)
+	}
+
+	if len(targets) == len(rule.Targets) {
+		l.Info(
+			"pagerduty-connector: principal is not a target of escalation level",
+			zap.String("principal_id", principal.Id.Resource),
+			zap.String("entitlement_id", entitlement.Id),
+		)
+
+		return nil, nil
+	}
+
+	// PagerDuty rejects escalation rules without any target
+	if len(targets) == 0 {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: cannot revoke %s from level %d of escalation policy %s: it is the only target of that level",
+			principal.Id.Resource,
+			idx+1,
+			policy.Name,
+		)
+	}
+
+	rule.Targets = targets
+
+	// revoke escalation level
+	_, err = e.client.UpdateEscalationPolicyWithContext(ctx, policy.ID, *policy)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to revoke escalation level: %w", err)
+	}
+
+	return nil, nil
+}
+
+func escalationPolicyBuilder(client *pagerduty.Client) *escalationPolicyResourceType {
+	return &escalationPolicyResourceType{
+		resourceType: resourceTypeEscalationPolicy,
+		client:       client,
+		onCalls:      newOnCallSnapshot(client),
+	}
+}
@@ -0,0 +1,31 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseEscalationLevel(t *testing.T) {
+	level, err := parseEscalationLevel(escalationLevelEntitlement(2))
+	if err != nil || level != 2 {
+		t.Fatalf("unexpected level %d, error %v", level, err)
+	}
+
+	for _, slug := range []string{escalationOnCallEntitlement(2), "level-0-target", "level-2-target-extra", "member"} {
+		if _, err := parseEscalationLevel(slug); err == nil {
+			t.Fatalf("expected %s to be rejected", slug)
+		}
+	}
+}
+
+func TestEscalationOnCallIsNotProvisioned(t *testing.T) {
+	e := escalationPolicyBuilder(nil)
+
+	_, _, err := e.getEscalationRule(context.Background(), "PPOLICY", escalationOnCallEntitlement(1))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a failed precondition, got %v", err)
+	}
+}
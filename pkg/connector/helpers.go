@@ -1,8 +1,11 @@
 package connector
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/PagerDuty/go-pagerduty"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
@@ -10,11 +13,23 @@ import (
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const ResourcesPageSize = 50
 
+const (
+	// offsetCap is the highest offset (plus limit) PagerDuty accepts for classic pagination
+	offsetCap = 10000
+	// maxPartitionDepth is the longest query prefix a collection is partitioned into
+	maxPartitionDepth = 3
+)
+
+// partitionAlphabet are the characters appended to the query when partitioning a collection.
+const partitionAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
 func titleCase(s string) string {
 	titleCaser := cases.Title(language.English)
 
@@ -27,21 +42,238 @@ func annotationsForUserResourceType() annotations.Annotations {
 	return annos
 }
 
-func handleNextPage(bag *pagination.Bag, page uint) (string, error) {
-	nextPage := strconv.FormatUint(uint64(page), 10)
-	pageToken, err := bag.NextToken(nextPage)
+// pageCursor is the position within an offset paginated collection, optionally narrowed to a query partition.
+type pageCursor struct {
+	Query  string `json:"query,omitempty"`
+	Offset uint   `json:"offset,omitempty"`
+	// Total is the size of a collection that reached the offset cap, set on each of its partitions
+	Total uint `json:"total,omitempty"`
+	// Counted is the number of items counted by the partitions done so far, handed from one partition to the next
+	Counted uint `json:"counted,omitempty"`
+	// Matched is the number of items counted by the current partition so far
+	Matched uint `json:"matched,omitempty"`
+}
+
+// partitioned reports whether the cursor is within a partition of a collection that reached the offset cap.
+func (c pageCursor) partitioned() bool {
+	return c.Total > 0
+}
+
+// wantsTotal reports whether the page at the cursor must be listed with total=true. That is the last page of a
+// collection before the offset cap, the total is needed to check the partitions replacing the remaining pages.
+func (c pageCursor) wantsTotal() bool {
+	return !c.partitioned() && c.Offset+2*ResourcesPageSize > offsetCap
+}
+
+// listedPage is what handleNextPage needs to know about the page listed at the cursor.
+type listedPage struct {
+	More bool
+	// Total is only returned by PagerDuty for pages listed with total=true
+	Total uint
+	// Names of the listed items, counted once the collection is partitioned
+	Names []string
+}
+
+// handleNextPage returns the token of the page following the cursor, or of the next pending partition.
+//
+// PagerDuty refuses offsets past offsetCap. When a partitionable collection (one that supports the `query`
+// filter) reaches the cap, the remaining pages are replaced by one partition per character of
+// partitionAlphabet appended to the current query. Partitions don't match items whose name continues with
+// other characters, or ends at the query, so the items listed by the partitions are counted and checked
+// against the total of the collection once the last partition is done.
+//
+// Partitions overlap, the query also matches other parts of the name (and the email of users). Resources and
+// grants are deduplicated by ID when stored, but the count only includes the items whose name starts with the
+// query of the partition: each item is counted by at most one partition, and the page token stays the same size
+// however large the collection is. A partition split further drops its count, its sub-partitions count again.
+// When a collection can't be listed completely an error is returned instead of silently truncating it.
+func handleNextPage(bag *pagination.Bag, cursor pageCursor, page listedPage, partitionable bool) (string, error) {
+	if cursor.partitioned() {
+		cursor.Matched += countPrefixed(page.Names, cursor.Query)
+	}
+
+	if !page.More {
+		// this collection (or partition) is done, move on to the next pending partition if any
+		done := bag.Pop()
+		if done == nil {
+			return "", fmt.Errorf("no active page state")
+		}
+
+		if cursor.partitioned() {
+			err := handOverCount(bag, *done, cursor)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return bag.Marshal()
+	}
+
+	next := pageCursor{
+		Query:   cursor.Query,
+		Offset:  cursor.Offset + ResourcesPageSize,
+		Total:   cursor.Total,
+		Counted: cursor.Counted,
+		Matched: cursor.Matched,
+	}
+
+	if next.Offset+ResourcesPageSize <= offsetCap {
+		token, err := json.Marshal(next)
+		if err != nil {
+			return "", err
+		}
+
+		return bag.NextToken(string(token))
+	}
+
+	current := bag.Pop()
+	if current == nil {
+		return "", fmt.Errorf("no active page state")
+	}
+
+	if !partitionable || len(cursor.Query) >= maxPartitionDepth {
+		return "", errOffsetCapReached(fmt.Sprintf("%s (query %q)", current.ResourceTypeID, cursor.Query))
+	}
+
+	total := cursor.Total
+	if !cursor.partitioned() {
+		total = page.Total
+	}
+
+	// without the total the partitions can't be checked
+	if total == 0 {
+		return "", errOffsetCapReached(fmt.Sprintf("%s (unknown total)", current.ResourceTypeID))
+	}
+
+	for i := range len(partitionAlphabet) {
+		partition := pageCursor{
+			Query: cursor.Query + partitionAlphabet[i:i+1],
+			Total: total,
+		}
+
+		// the last partition pushed is listed first, it takes over the count of the partitions done so far
+		if i == len(partitionAlphabet)-1 {
+			partition.Counted = cursor.Counted
+		}
+
+		token, err := json.Marshal(partition)
+		if err != nil {
+			return "", err
+		}
+
+		bag.Push(pagination.PageState{
+			Token:          string(token),
+			ResourceTypeID: current.ResourceTypeID,
+			ResourceID:     current.ResourceID,
+		})
+	}
+
+	return bag.Marshal()
+}
+
+// handOverCount hands the count of a finished partition to the next partition of the collection. After the last
+// partition it checks the count against the total of the collection.
+func handOverCount(bag *pagination.Bag, done pagination.PageState, cursor pageCursor) error {
+	counted := cursor.Counted + cursor.Matched
+
+	next := bag.Current()
+	if next != nil && next.ResourceTypeID == done.ResourceTypeID && next.ResourceID == done.ResourceID {
+		nextCursor, err := convertPageToken(next.Token)
+		if err != nil {
+			return err
+		}
+
+		if nextCursor.partitioned() {
+			nextCursor.Counted = counted
+
+			token, err := json.Marshal(nextCursor)
+			if err != nil {
+				return err
+			}
+
+			return bag.Next(string(token))
+		}
+	}
+
+	// items created during the sync may push the count over the total, fewer means some were missed
+	if counted < cursor.Total {
+		return errOffsetCapReached(fmt.Sprintf("%s (%d of %d items matched by query partitions)", done.ResourceTypeID, counted, cursor.Total))
+	}
+
+	return nil
+}
+
+// listedNames returns the names of the listed items.
+func listedNames[T any](items []T, name func(T) string) []string {
+	rv := make([]string, 0, len(items))
+	for _, item := range items {
+		rv = append(rv, name(item))
+	}
+
+	return rv
+}
+
+// countPrefixed counts the names starting with the query, ignoring case like PagerDuty's query filter does.
+func countPrefixed(names []string, query string) uint {
+	var rv uint
+	for _, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), query) {
+			rv++
+		}
+	}
+
+	return rv
+}
+
+// errOffsetCapReached reports a collection that can't be listed completely because of PagerDuty's offset cap.
+func errOffsetCapReached(collection string) error {
+	return status.Errorf(
+		codes.ResourceExhausted,
+		"pagerduty-connector: %s collection has more than %d items and can't be partitioned further, refusing to truncate it",
+		collection,
+		offsetCap,
+	)
+}
+
+// nextOffset moves the offset of a collection listed in full to its next page, or reports the offset cap when the
+// next page is past it.
+func nextOffset[T int | uint](offset *T, collection string) error {
+	*offset += ResourcesPageSize
+	if *offset+ResourcesPageSize > offsetCap {
+		return errOffsetCapReached(collection)
+	}
+
+	return nil
+}
+
+// doAPIRequest sends a request go-pagerduty doesn't expose and decodes the response into v, when set.
+// Failed requests return a pagerduty.APIError, decoded the same way go-pagerduty does so callers can inspect it.
+func doAPIRequest(client *pagerduty.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req, true)
 	if err != nil {
-		return "", err
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := pagerduty.APIError{StatusCode: resp.StatusCode}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+
+		return apiErr
 	}
 
-	return pageToken, nil
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
-func parsePageToken(i string, resourceID *v2.ResourceId) (*pagination.Bag, uint, error) {
+func parsePageToken(i string, resourceID *v2.ResourceId) (*pagination.Bag, pageCursor, error) {
 	b := &pagination.Bag{}
 	err := b.Unmarshal(i)
 	if err != nil {
-		return nil, 0, err
+		return nil, pageCursor{}, err
 	}
 
 	if b.Current() == nil {
@@ -51,26 +283,31 @@ func parsePageToken(i string, resourceID *v2.ResourceId) (*pagination.Bag, uint,
 		})
 	}
 
-	page, err := convertPageToken(b.PageToken())
+	cursor, err := convertPageToken(b.PageToken())
 	if err != nil {
-		return nil, 0, err
+		return nil, pageCursor{}, err
 	}
 
-	return b, page, nil
+	return b, cursor, nil
 }
 
-// convertPageToken converts a string token into an int.
-func convertPageToken(token string) (uint, error) {
+// convertPageToken converts a string token into a page cursor, plain offsets from older tokens are accepted as well.
+func convertPageToken(token string) (pageCursor, error) {
 	if token == "" {
-		return 0, nil
+		return pageCursor{}, nil
+	}
+
+	if page, err := strconv.ParseUint(token, 10, 32); err == nil {
+		return pageCursor{Offset: uint(page)}, nil
 	}
 
-	page, err := strconv.ParseUint(token, 10, 32)
+	var cursor pageCursor
+	err := json.Unmarshal([]byte(token), &cursor)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse page token: %w", err)
+		return pageCursor{}, fmt.Errorf("failed to parse page token: %w", err)
 	}
 
-	return uint(page), nil
+	return cursor, nil
 }
 
 func getProfileStringArray(profile *structpb.Struct, k string) ([]string, bool) {
@@ -0,0 +1,327 @@
+package connector
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testBag(t *testing.T, cursors ...pageCursor) *pagination.Bag {
+	t.Helper()
+
+	bag := &pagination.Bag{}
+	for _, cursor := range cursors {
+		token, err := json.Marshal(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bag.Push(pagination.PageState{
+			Token:          string(token),
+			ResourceTypeID: resourceTypeUser.Id,
+		})
+	}
+
+	return bag
+}
+
+// currentCursor decodes the cursor of the page a token points to.
+func currentCursor(t *testing.T, token string) (pageCursor, *pagination.Bag) {
+	t.Helper()
+
+	bag := &pagination.Bag{}
+	if err := bag.Unmarshal(token); err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := convertPageToken(bag.PageToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cursor, bag
+}
+
+func assertOffsetCapReached(t *testing.T, err error) {
+	t.Helper()
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected the offset cap error, got %v", err)
+	}
+}
+
+func TestConvertPageToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		want    pageCursor
+		wantErr bool
+	}{
+		{name: "empty", token: "", want: pageCursor{}},
+		{name: "plain offset", token: "150", want: pageCursor{Offset: 150}},
+		{name: "cursor", token: `{"query":"ab","offset":100,"total":12000,"counted":40,"matched":3}`, want: pageCursor{Query: "ab", Offset: 100, Total: 12000, Counted: 40, Matched: 3}},
+		{name: "invalid", token: "{", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertPageToken(tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageCursorWantsTotal(t *testing.T) {
+	if (pageCursor{Offset: 0}).wantsTotal() {
+		t.Fatal("the first page doesn't need the total")
+	}
+
+	if !(pageCursor{Offset: offsetCap - 2*ResourcesPageSize + 1}).wantsTotal() {
+		t.Fatal("the last page before the cap needs the total")
+	}
+
+	if (pageCursor{Offset: offsetCap - ResourcesPageSize, Total: 12000}).wantsTotal() {
+		t.Fatal("partitions already know the total")
+	}
+}
+
+func TestHandleNextPageWithinCap(t *testing.T) {
+	cursor := pageCursor{Offset: 100}
+
+	token, err := handleNextPage(testBag(t, cursor), cursor, listedPage{More: true}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, _ := currentCursor(t, token)
+	if next.Offset != 150 || next.Query != "" {
+		t.Fatalf("unexpected next cursor %+v", next)
+	}
+}
+
+func TestHandleNextPageDone(t *testing.T) {
+	cursor := pageCursor{Offset: 100}
+
+	token, err := handleNextPage(testBag(t, cursor), cursor, listedPage{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		t.Fatalf("expected no next page, got %q", token)
+	}
+}
+
+func TestHandleNextPagePartitionsAtCap(t *testing.T) {
+	cursor := pageCursor{Offset: offsetCap - 2*ResourcesPageSize + 50}
+
+	token, err := handleNextPage(testBag(t, cursor), cursor, listedPage{More: true, Total: 12000}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, bag := currentCursor(t, token)
+	if next.Query != "9" || next.Offset != 0 || next.Total != 12000 {
+		t.Fatalf("unexpected first partition %+v", next)
+	}
+
+	partitions := 0
+	for bag.Current() != nil {
+		partitions++
+		bag.Pop()
+	}
+
+	if partitions != len(partitionAlphabet) {
+		t.Fatalf("expected %d partitions, got %d", len(partitionAlphabet), partitions)
+	}
+}
+
+func TestHandleNextPageCapWithoutTotal(t *testing.T) {
+	cursor := pageCursor{Offset: offsetCap - 2*ResourcesPageSize + 50}
+
+	_, err := handleNextPage(testBag(t, cursor), cursor, listedPage{More: true}, true)
+	assertOffsetCapReached(t, err)
+}
+
+func TestHandleNextPageCapNotPartitionable(t *testing.T) {
+	cursor := pageCursor{Offset: offsetCap - 2*ResourcesPageSize + 50}
+
+	_, err := handleNextPage(testBag(t, cursor), cursor, listedPage{More: true, Total: 12000}, false)
+	assertOffsetCapReached(t, err)
+}
+
+func TestHandleNextPageCapMaxDepth(t *testing.T) {
+	cursor := pageCursor{Query: "abc", Offset: offsetCap - 2*ResourcesPageSize + 50, Total: 12000}
+
+	_, err := handleNextPage(testBag(t, cursor), cursor, listedPage{More: true}, true)
+	assertOffsetCapReached(t, err)
+}
+
+func TestHandleNextPageSplitsPartition(t *testing.T) {
+	cursor := pageCursor{Query: "a", Offset: offsetCap - 2*ResourcesPageSize + 50, Total: 12000, Counted: 7, Matched: 9000}
+
+	token, err := handleNextPage(testBag(t, pageCursor{Query: "b", Total: 12000}, cursor), cursor, listedPage{More: true, Names: []string{"Alice"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sub-partitions count the items of the split partition again
+	next, _ := currentCursor(t, token)
+	if next.Query != "a9" || next.Total != 12000 || next.Counted != 7 || next.Matched != 0 {
+		t.Fatalf("unexpected first sub-partition %+v", next)
+	}
+}
+
+func TestHandleNextPageHandsOverCount(t *testing.T) {
+	cursor := pageCursor{Query: "9", Offset: 50, Total: 3, Counted: 1, Matched: 1}
+
+	token, err := handleNextPage(testBag(t, pageCursor{Query: "8", Total: 3}, cursor), cursor, listedPage{Names: []string{"9 Lives", "Team 9"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "Team 9" is counted by the "t" partition
+	next, _ := currentCursor(t, token)
+	if next.Query != "8" || next.Counted != 3 || next.Matched != 0 {
+		t.Fatalf("unexpected next partition %+v", next)
+	}
+}
+
+func TestHandleNextPageLastPartition(t *testing.T) {
+	cursor := pageCursor{Query: "a", Total: 3, Counted: 1}
+
+	token, err := handleNextPage(testBag(t, cursor), cursor, listedPage{Names: []string{"Alice", "ANDREW", "Bob Adams"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		t.Fatalf("expected no next page, got %q", token)
+	}
+}
+
+func TestHandleNextPageLastPartitionMissedItems(t *testing.T) {
+	cursor := pageCursor{Query: "a", Total: 4, Counted: 1}
+
+	_, err := handleNextPage(testBag(t, cursor), cursor, listedPage{Names: []string{"Alice", "ANDREW", "Bob Adams"}}, true)
+	assertOffsetCapReached(t, err)
+}
+
+// listPartitioned lists a collection of names the way a partitionable syncer does, PagerDuty's query matching any
+// part of the name, and returns the distinct names listed and the largest page token.
+func listPartitioned(t *testing.T, names []string) (map[string]bool, int, error) {
+	t.Helper()
+
+	matching := make(map[string][]string)
+	listed := make(map[string]bool)
+	token, largest := "", 0
+	for {
+		bag, cursor, err := parsePageToken(token, &v2.ResourceId{ResourceType: resourceTypeUser.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := matching[cursor.Query]; !ok {
+			for _, name := range names {
+				if strings.Contains(strings.ToLower(name), cursor.Query) {
+					matching[cursor.Query] = append(matching[cursor.Query], name)
+				}
+			}
+		}
+
+		items := matching[cursor.Query]
+		end := min(int(cursor.Offset)+ResourcesPageSize, len(items))
+		page := listedPage{
+			More:  end < len(items),
+			Names: items[cursor.Offset:end],
+		}
+		if cursor.wantsTotal() {
+			page.Total = uint(len(items))
+		}
+
+		for _, name := range page.Names {
+			listed[name] = true
+		}
+
+		token, err = handleNextPage(bag, cursor, page, true)
+		if err != nil {
+			return listed, largest, err
+		}
+
+		if token == "" {
+			return listed, largest, nil
+		}
+
+		largest = max(largest, len(token))
+	}
+}
+
+// partitionNames returns distinct names spread over the partition alphabet.
+func partitionNames(count int) []string {
+	rv := make([]string, 0, count)
+	for i := range count {
+		name := make([]byte, 0, 4)
+		for n := i; len(name) < 4; n /= len(partitionAlphabet) {
+			name = append(name, partitionAlphabet[n%len(partitionAlphabet)])
+		}
+
+		rv = append(rv, strings.ToUpper(string(name[:1]))+string(name[1:]))
+	}
+
+	return rv
+}
+
+func TestHandleNextPageLargePartitionedCollection(t *testing.T) {
+	names := partitionNames(25000)
+
+	listed, largest, err := listPartitioned(t, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(listed) != len(names) {
+		t.Fatalf("listed %d of %d items", len(listed), len(names))
+	}
+
+	// the token holds the pending partitions, not the items listed so far
+	if largest > 8*1024 {
+		t.Fatalf("page token grew to %d bytes", largest)
+	}
+}
+
+func TestHandleNextPageLargeCollectionMissedItems(t *testing.T) {
+	// names starting with other characters than the partition alphabet aren't matched by any partition
+	names := append(partitionNames(12000), "Émile", "_svc-bot")
+
+	_, _, err := listPartitioned(t, names)
+	assertOffsetCapReached(t, err)
+}
+
+func TestNextOffset(t *testing.T) {
+	offset := uint(100)
+	if err := nextOffset(&offset, "user"); err != nil || offset != 150 {
+		t.Fatalf("unexpected offset %d, error %v", offset, err)
+	}
+
+	offset = offsetCap - 2*ResourcesPageSize + 50
+	assertOffsetCapReached(t, nextOffset(&offset, "user"))
+}
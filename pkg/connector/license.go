@@ -0,0 +1,309 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	licenseAssigned = "assigned"
+)
+
+type licenseResourceType struct {
+	resourceType *v2.ResourceType
+	client       *pagerduty.Client
+	apiEndpoint  string
+	users        *userCache
+	licenses     *licenseAllocations
+}
+
+func (l *licenseResourceType) ResourceType(_ context.Context) *v2.ResourceType {
+	return l.resourceType
+}
+
+// licenseResource creates a new connector resource for a PagerDuty License.
+func licenseResource(license *pagerduty.License) (*v2.Resource, error) {
+	validRoles := make([]interface{}, 0, len(license.ValidRoles))
+	for _, role := range license.ValidRoles {
+		validRoles = append(validRoles, role)
+	}
+
+	profile := map[string]interface{}{
+		"license_id":            license.ID,
+		"license_name":          license.Name,
+		"role_group":            license.RoleGroup,
+		"valid_roles":           validRoles,
+		"allocations":           license.CurrentValue,
+		"allocations_available": license.AllocationsAvailable,
+	}
+
+	resource, err := rs.NewRoleResource(
+		license.Name,
+		resourceTypeLicense,
+		license.ID,
+		[]rs.RoleTraitOption{rs.WithRoleProfile(profile)},
+		rs.WithDescription(license.Description),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (l *licenseResourceType) List(ctx context.Context, parentID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	licensesResponse, err := l.client.ListLicensesWithContext(ctx)
+	if err != nil {
+		if licensesUnsupported(err) {
+			return nil, "", rl.annotations(), nil
+		}
+
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list licenses: %w", err)
+	}
+
+	rv := make([]*v2.Resource, 0, len(licensesResponse.Licenses))
+	for _, license := range licensesResponse.Licenses {
+		lr, err := licenseResource(&license) // #nosec G601
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, lr)
+	}
+
+	return rv, "", rl.annotations(), nil
+}
+
+func (l *licenseResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	entitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeUser),
+		ent.WithDisplayName(fmt.Sprintf("%s license", resource.DisplayName)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty license", resource.DisplayName)),
+	}
+
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, licenseAssigned, entitlementOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (l *licenseResourceType) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	if pToken.Token == "" {
+		userIDs, ok, err := l.licenses.usersWithLicense(ctx, resource.Id.Resource)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if ok {
+			rv := make([]*v2.Grant, 0, len(userIDs))
+			for _, userID := range userIDs {
+				rv = append(rv, licenseGrant(resource, userID))
+			}
+
+			return rv, "", rl.annotations(), nil
+		}
+
+		// the allocations reached the offset cap and the users weren't all listed yet, this listing fetches the
+		// license of the remaining users for the other licenses
+		l.licenses.reset()
+	}
+
+	bag, cursor, err := parsePageToken(pToken.Token, resource.Id)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	usersResponse, err := l.client.ListUsersWithContext(ctx, pagerduty.ListUsersOptions{
+		Limit:  ResourcesPageSize,
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list users: %w", err)
+	}
+
+	var rv []*v2.Grant
+	for _, user := range usersResponse.Users {
+		license, _, err := l.licenses.forUser(ctx, user.ID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if license != nil && license.ID == resource.Id.Resource {
+			rv = append(rv, licenseGrant(resource, user.ID))
+		}
+	}
+
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  usersResponse.More,
+		Total: usersResponse.Total,
+		Names: listedNames(usersResponse.Users, func(user pagerduty.User) string { return user.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if pageToken == "" {
+		l.licenses.markComplete()
+	}
+
+	return rv, pageToken, rl.annotations(), nil
+}
+
+func licenseGrant(resource *v2.Resource, userID string) *v2.Grant {
+	return grant.NewGrant(
+		resource,
+		licenseAssigned,
+		&v2.ResourceId{
+			ResourceType: resourceTypeUser.Id,
+			Resource:     userID,
+		},
+	)
+}
+
+func (l *licenseResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	lg := ctxzap.Extract(ctx)
+
+	if principal.Id.ResourceType != resourceTypeUser.Id {
+		lg.Warn(
+			"pagerduty-connector: only users can be granted license",
+			zap.String("principal_id", principal.Id.Resource),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, fmt.Errorf("pagerduty-connector: only users can be granted license")
+	}
+
+	userID, licenseID := principal.Id.Resource, entitlement.Resource.Id.Resource
+
+	current, _, err := getUserLicense(ctx, l.client, l.apiEndpoint, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && current.ID == licenseID {
+		lg.Info(
+			"pagerduty-connector: user already has the license",
+			zap.String("principal_id", userID),
+			zap.String("license_id", licenseID),
+		)
+
+		return nil, nil
+	}
+
+	license, err := getLicense(ctx, l.client, licenseID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := l.users.refresh(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(license.ValidRoles, user.Role) {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: license %s doesn't allow the %s role of the user, allowed roles: %s",
+			license.Name,
+			user.Role,
+			strings.Join(license.ValidRoles, ", "),
+		)
+	}
+
+	if license.AllocationsAvailable <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "pagerduty-connector: license %s has no allocations available", license.Name)
+	}
+
+	err = updateUserLicense(ctx, l.client, l.apiEndpoint, userID, licenseID, "")
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to grant license %s: %w", license.Name, err)
+	}
+
+	return nil, nil
+}
+
+func (l *licenseResourceType) Revoke(_ context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	// every user of an account with licenses holds one, it can only be replaced by granting another one
+	return nil, status.Errorf(
+		codes.FailedPrecondition,
+		"pagerduty-connector: license %s can't be revoked, grant another license to the user instead",
+		grant.Entitlement.Resource.DisplayName,
+	)
+}
+
+// getLicense returns the license with its valid roles and available allocations.
+func getLicense(ctx context.Context, client *pagerduty.Client, licenseID string) (*pagerduty.License, error) {
+	licensesResponse, err := client.ListLicensesWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to list licenses: %w", err)
+	}
+
+	for _, license := range licensesResponse.Licenses {
+		if license.ID == licenseID {
+			return &license, nil // #nosec G601
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "pagerduty-connector: license %s not found", licenseID)
+}
+
+// updateUserLicense moves the user onto the license, and changes the base role of the user in the same update when set.
+func updateUserLicense(ctx context.Context, client *pagerduty.Client, apiEndpoint string, userID string, licenseID string, role string) error {
+	user := map[string]interface{}{
+		"type": "user",
+		"license": map[string]string{
+			"id":   licenseID,
+			"type": "license_reference",
+		},
+	}
+
+	if role != "" {
+		user["role"] = role
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"user": user})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/users/%s", apiEndpoint, userID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	return doAPIRequest(client, req, nil)
+}
+
+func licenseBuilder(client *pagerduty.Client, apiEndpoint string, users *userCache, licenses *licenseAllocations) *licenseResourceType {
+	return &licenseResourceType{
+		resourceType: resourceTypeLicense,
+		client:       client,
+		apiEndpoint:  apiEndpoint,
+		users:        users,
+		licenses:     licenses,
+	}
+}
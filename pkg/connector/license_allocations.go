@@ -0,0 +1,210 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// licenseAllocations holds the license allocated to each user, taken once per sync.
+//
+// Allocations can only be listed up to the offset cap. Past it, the license of the remaining users is fetched
+// with each of them while users are listed.
+type licenseAllocations struct {
+	client      *pagerduty.Client
+	apiEndpoint string
+
+	mu sync.Mutex
+	// byUser holds the license of each user known so far, nil for users without one
+	byUser map[string]*pagerduty.LicenseAllocated
+	// supported is false for accounts without licenses, their users are not expected to have one
+	supported bool
+	taken     bool
+	// err is the failure to list the allocations, kept until the next sync instead of retrying for every user
+	err error
+	// perUser is set when the allocations couldn't all be listed, users missing from byUser are then fetched
+	perUser bool
+	// listing and complete track a listing of every user filling byUser, like the userCache does
+	listing  bool
+	complete bool
+}
+
+func newLicenseAllocations(client *pagerduty.Client, apiEndpoint string) *licenseAllocations {
+	return &licenseAllocations{
+		client:      client,
+		apiEndpoint: apiEndpoint,
+	}
+}
+
+// reset drops the allocations, it is called when a listing of every user starts from the first page.
+func (l *licenseAllocations) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.byUser = nil
+	l.supported = false
+	l.taken = false
+	l.err = nil
+	l.perUser = false
+	l.listing = true
+	l.complete = false
+}
+
+// markComplete records that the license of every user is known. A listing resumed from a page token of another
+// process missed the first pages, it doesn't complete the allocations.
+func (l *licenseAllocations) markComplete() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.listing {
+		return
+	}
+
+	l.listing = false
+	l.complete = true
+}
+
+// licensesUnsupported reports whether the error comes from an account on a plan without licenses.
+func licensesUnsupported(err error) bool {
+	var apiErr pagerduty.APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusNotFound)
+}
+
+// ensureTaken lists the allocations unless they were already listed during this sync, the lock must be held.
+func (l *licenseAllocations) ensureTaken(ctx context.Context) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	if l.taken {
+		return nil
+	}
+
+	byUser, supported, complete, err := l.take(ctx)
+	if err != nil {
+		l.err = err
+		return err
+	}
+
+	l.byUser = byUser
+	l.supported = supported
+	l.perUser = !complete
+	l.taken = true
+
+	return nil
+}
+
+// usersWithLicense returns the IDs of the users the license is allocated to, and false when the license of some
+// users isn't known because the allocations reached the offset cap and not every user was listed yet.
+func (l *licenseAllocations) usersWithLicense(ctx context.Context, licenseID string) ([]string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.ensureTaken(ctx); err != nil {
+		return nil, false, err
+	}
+
+	if l.perUser && !l.complete {
+		return nil, false, nil
+	}
+
+	var rv []string
+	for userID, license := range l.byUser {
+		if license != nil && license.ID == licenseID {
+			rv = append(rv, userID)
+		}
+	}
+
+	return rv, true, nil
+}
+
+// forUser returns the license allocated to the user, and false when the account doesn't have licenses.
+func (l *licenseAllocations) forUser(ctx context.Context, userID string) (*pagerduty.LicenseAllocated, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.ensureTaken(ctx); err != nil {
+		return nil, false, err
+	}
+
+	if !l.supported {
+		return nil, false, nil
+	}
+
+	license, ok := l.byUser[userID]
+	if ok || !l.perUser {
+		return license, true, nil
+	}
+
+	license, _, err := getUserLicense(ctx, l.client, l.apiEndpoint, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	l.byUser[userID] = license
+
+	return license, true, nil
+}
+
+// take lists the allocations, it reports whether they could all be listed before the offset cap.
+func (l *licenseAllocations) take(ctx context.Context) (map[string]*pagerduty.LicenseAllocated, bool, bool, error) {
+	opts := pagerduty.ListLicenseAllocationsOptions{
+		Limit: ResourcesPageSize,
+	}
+
+	rv := make(map[string]*pagerduty.LicenseAllocated)
+	for {
+		allocationsResponse, err := l.client.ListLicenseAllocationsWithContext(ctx, opts)
+		if err != nil {
+			if licensesUnsupported(err) {
+				return nil, false, true, nil
+			}
+
+			return nil, false, false, fmt.Errorf("pagerduty-connector: failed to list license allocations: %w", err)
+		}
+
+		for _, allocation := range allocationsResponse.LicenseAllocations {
+			license := allocation.License
+			rv[allocation.User.ID] = &license
+		}
+
+		if !allocationsResponse.More {
+			return rv, true, true, nil
+		}
+
+		// the license of the users past the offset cap is fetched with each of them
+		if nextOffset(&opts.Offset, "license allocation") != nil {
+			return rv, true, false, nil
+		}
+	}
+}
+
+// getUserLicense returns the license currently allocated to the user, and false when the account doesn't have
+// licenses. Provisioning relies on it rather than on the allocations listed during the sync, which may be stale.
+func getUserLicense(ctx context.Context, client *pagerduty.Client, apiEndpoint string, userID string) (*pagerduty.LicenseAllocated, bool, error) {
+	u := fmt.Sprintf("%s/users/%s/license", apiEndpoint, url.PathEscape(userID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var licenseResponse struct {
+		License *pagerduty.LicenseAllocated `json:"license"`
+	}
+
+	err = doAPIRequest(client, req, &licenseResponse)
+	if err != nil {
+		if licensesUnsupported(err) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("pagerduty-connector: failed to get the license of the user: %w", err)
+	}
+
+	return licenseResponse.License, true, nil
+}
@@ -0,0 +1,153 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// testLicenseServer serves allocations of the license PLICENSE to the users P0 to P<allocated-1>, and the license
+// of single users.
+func testLicenseServer(t *testing.T, allocated int) (*pagerduty.Client, string, *int) {
+	t.Helper()
+
+	userLookups := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/license_allocations", func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		end := min(offset+ResourcesPageSize, allocated)
+
+		allocations := make([]pagerduty.LicenseAllocation, 0, ResourcesPageSize)
+		for i := offset; i < end; i++ {
+			allocations = append(allocations, pagerduty.LicenseAllocation{
+				User:    pagerduty.APIObject{ID: fmt.Sprintf("P%d", i)},
+				License: pagerduty.LicenseAllocated{APIObject: pagerduty.APIObject{ID: "PLICENSE"}},
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(pagerduty.ListLicenseAllocationsResponse{
+			APIListObject:      pagerduty.APIListObject{More: end < allocated},
+			LicenseAllocations: allocations,
+		})
+	})
+	mux.HandleFunc("/users/{id}/license", func(w http.ResponseWriter, r *http.Request) {
+		userLookups++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"license": pagerduty.LicenseAllocated{APIObject: pagerduty.APIObject{ID: "PLICENSE"}},
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return pagerduty.NewClient("token", pagerduty.WithAPIEndpoint(server.URL)), server.URL, &userLookups
+}
+
+func TestLicenseAllocationsWithinCap(t *testing.T) {
+	client, apiEndpoint, userLookups := testLicenseServer(t, 120)
+	ctx := context.Background()
+
+	l := newLicenseAllocations(client, apiEndpoint)
+	l.reset()
+
+	license, licensed, err := l.forUser(ctx, "P7")
+	if err != nil || !licensed || license == nil || license.ID != "PLICENSE" {
+		t.Fatalf("unexpected license %v, licensed %v, error %v", license, licensed, err)
+	}
+
+	license, licensed, err = l.forUser(ctx, "PUNLICENSED")
+	if err != nil || !licensed || license != nil {
+		t.Fatalf("unexpected license %v, licensed %v, error %v", license, licensed, err)
+	}
+
+	userIDs, ok, err := l.usersWithLicense(ctx, "PLICENSE")
+	if err != nil || !ok || len(userIDs) != 120 {
+		t.Fatalf("got %d users, error %v", len(userIDs), err)
+	}
+
+	if *userLookups != 0 {
+		t.Fatalf("expected no user lookups, got %d", *userLookups)
+	}
+}
+
+func TestLicenseAllocationsPastCap(t *testing.T) {
+	client, apiEndpoint, userLookups := testLicenseServer(t, offsetCap+500)
+	ctx := context.Background()
+
+	l := newLicenseAllocations(client, apiEndpoint)
+	l.reset()
+
+	// users listed before the cap don't need a lookup, the others are fetched
+	for _, userID := range []string{"P10", fmt.Sprintf("P%d", offsetCap+100)} {
+		license, licensed, err := l.forUser(ctx, userID)
+		if err != nil || !licensed || license == nil || license.ID != "PLICENSE" {
+			t.Fatalf("%s: unexpected license %v, licensed %v, error %v", userID, license, licensed, err)
+		}
+	}
+
+	if *userLookups != 1 {
+		t.Fatalf("expected one user lookup, got %d", *userLookups)
+	}
+
+	if _, ok, err := l.usersWithLicense(ctx, "PLICENSE"); ok || err != nil {
+		t.Fatalf("expected the license users to be unknown before every user is listed, got %v", err)
+	}
+
+	l.markComplete()
+
+	userIDs, ok, err := l.usersWithLicense(ctx, "PLICENSE")
+	if err != nil || !ok || len(userIDs) != offsetCap+1 {
+		t.Fatalf("got %d users, error %v", len(userIDs), err)
+	}
+}
+
+func TestLicenseAllocationsFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	l := newLicenseAllocations(pagerduty.NewClient("token", pagerduty.WithAPIEndpoint(server.URL)), server.URL)
+	l.reset()
+
+	for range 3 {
+		if _, _, err := l.forUser(context.Background(), "P1"); err == nil {
+			t.Fatal("expected the listing failure")
+		}
+	}
+
+	// the failure is kept for the sync instead of listing again for every user
+	if requests != 1 {
+		t.Fatalf("expected a single listing, got %d requests", requests)
+	}
+}
+
+func TestGetUserLicense(t *testing.T) {
+	client, apiEndpoint, userLookups := testLicenseServer(t, 0)
+
+	license, licensed, err := getUserLicense(context.Background(), client, apiEndpoint, "P1")
+	if err != nil || !licensed || license == nil || license.ID != "PLICENSE" {
+		t.Fatalf("unexpected license %v, licensed %v, error %v", license, licensed, err)
+	}
+
+	// provisioning looks up the single user, it doesn't list the allocations
+	if *userLookups != 1 {
+		t.Fatalf("expected one user lookup, got %d", *userLookups)
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	license, licensed, err = getUserLicense(context.Background(), pagerduty.NewClient("token", pagerduty.WithAPIEndpoint(server.URL)), server.URL, "P1")
+	if err != nil || licensed || license != nil {
+		t.Fatalf("expected an account without licenses, got %v, licensed %v, error %v", license, licensed, err)
+	}
+}
@@ -0,0 +1,140 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tokenURL is the PagerDuty endpoint exchanging app credentials for access tokens, shared by all service regions.
+const tokenURL = "https://identity.pagerduty.com/oauth/token"
+
+// readScopes are the OAuth scopes needed to sync each resource type.
+var readScopes = map[string][]string{
+	resourceTypeTeam.Id:             {"teams.read", "abilities.read"},
+	resourceTypeUser.Id:             {"users.read", "users:contact_methods.read", "licenses.read"},
+	resourceTypeRole.Id:             {"users.read", "licenses.read"},
+	resourceTypeLicense.Id:          {"licenses.read", "users.read"},
+	resourceTypeSchedule.Id:         {"schedules.read", "oncalls.read"},
+	resourceTypeScheduleLayer.Id:    {"schedules.read"},
+	resourceTypeEscalationPolicy.Id: {"escalation_policies.read", "oncalls.read"},
+	resourceTypeService.Id:          {"services.read"},
+	resourceTypeBusinessService.Id:  {"services.read"},
+}
+
+// writeScopes are the additional OAuth scopes needed to provision each resource type.
+var writeScopes = map[string][]string{
+	resourceTypeUser.Id:             {"users.write", "users:contact_methods.write", "schedules.write", "escalation_policies.write"},
+	resourceTypeTeam.Id:             {"teams.write"},
+	resourceTypeRole.Id:             {"users.write"},
+	resourceTypeLicense.Id:          {"users.write"},
+	resourceTypeSchedule.Id:         {"schedules.write"},
+	resourceTypeEscalationPolicy.Id: {"escalation_policies.write"},
+}
+
+// OAuthApp holds the credentials of a scoped PagerDuty OAuth app, used instead of a REST API key.
+type OAuthApp struct {
+	ClientID     string
+	ClientSecret string
+	// Subdomain and Region identify the PagerDuty account the app is installed in
+	Subdomain string
+	Region    string
+	// Scopes overrides the scopes requested for the access token, defaults to the scopes needed by the connector
+	Scopes []string
+	// Provisioning requests the write scopes on top of the read ones
+	Provisioning bool
+}
+
+// requiredScopes returns the scopes needed by each resource type.
+func (o *OAuthApp) requiredScopes() map[string][]string {
+	rv := make(map[string][]string, len(readScopes))
+	for resourceType, scopes := range readScopes {
+		rv[resourceType] = append(rv[resourceType], scopes...)
+	}
+
+	if o.Provisioning {
+		for resourceType, scopes := range writeScopes {
+			rv[resourceType] = append(rv[resourceType], scopes...)
+		}
+	}
+
+	return rv
+}
+
+// scopes returns the scopes requested for the access token.
+func (o *OAuthApp) scopes() []string {
+	// the account scope selects the account the token is issued for
+	rv := []string{fmt.Sprintf("as_account-%s.%s", o.Region, o.Subdomain)}
+
+	if len(o.Scopes) > 0 {
+		return append(rv, o.Scopes...)
+	}
+
+	for _, scopes := range o.requiredScopes() {
+		for _, scope := range scopes {
+			if !slices.Contains(rv, scope) {
+				rv = append(rv, scope)
+			}
+		}
+	}
+
+	sort.Strings(rv[1:])
+
+	return rv
+}
+
+// tokenSource returns the source of access tokens, it caches the token and exchanges a new one before it expires.
+func (o *OAuthApp) tokenSource(ctx context.Context, httpClient *http.Client) oauth2.TokenSource {
+	cfg := &clientcredentials.Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       o.scopes(),
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+
+	// token exchanges go through the same proxy and TLS settings as the API requests
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+
+	return cfg.TokenSource(ctx)
+}
+
+// validateScopes checks that the access token was granted the scopes needed by every resource type.
+func (o *OAuthApp) validateScopes(ts oauth2.TokenSource) error {
+	token, err := ts.Token()
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "failed to exchange the PagerDuty OAuth client credentials: %v", err)
+	}
+
+	granted, _ := token.Extra("scope").(string)
+	grantedScopes := strings.Fields(granted)
+
+	var missing []string
+	for resourceType, scopes := range o.requiredScopes() {
+		var resourceMissing []string
+		for _, scope := range scopes {
+			if !slices.Contains(grantedScopes, scope) {
+				resourceMissing = append(resourceMissing, scope)
+			}
+		}
+
+		if len(resourceMissing) > 0 {
+			missing = append(missing, fmt.Sprintf("%s (%s)", resourceType, strings.Join(resourceMissing, ", ")))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return status.Errorf(codes.PermissionDenied, "PagerDuty OAuth app is missing scopes for: %s", strings.Join(missing, "; "))
+	}
+
+	return nil
+}
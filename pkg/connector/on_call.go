@@ -0,0 +1,80 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// onCallSnapshot holds the account-wide on-call entries taken once per sync, so that
+// every escalation policy reports who was on-call at the same moment.
+type onCallSnapshot struct {
+	client *pagerduty.Client
+
+	mu    sync.Mutex
+	byEP  map[string][]pagerduty.OnCall
+	taken bool
+}
+
+func newOnCallSnapshot(client *pagerduty.Client) *onCallSnapshot {
+	return &onCallSnapshot{
+		client: client,
+	}
+}
+
+// reset drops the snapshot, the next lookup takes a new one.
+func (o *onCallSnapshot) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.byEP = nil
+	o.taken = false
+}
+
+// forEscalationPolicy returns the current on-call entries of the escalation policy.
+func (o *onCallSnapshot) forEscalationPolicy(ctx context.Context, policyID string) ([]pagerduty.OnCall, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.taken {
+		byEP, err := o.take(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		o.byEP = byEP
+		o.taken = true
+	}
+
+	return o.byEP[policyID], nil
+}
+
+func (o *onCallSnapshot) take(ctx context.Context) (map[string][]pagerduty.OnCall, error) {
+	// without a time window PagerDuty returns the entries active right now
+	opts := pagerduty.ListOnCallOptions{
+		Limit:    ResourcesPageSize,
+		TimeZone: "UTC",
+	}
+
+	rv := make(map[string][]pagerduty.OnCall)
+	for {
+		onCallsResponse, err := o.client.ListOnCallsWithContext(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to list on-calls: %w", err)
+		}
+
+		for _, onCall := range onCallsResponse.OnCalls {
+			rv[onCall.EscalationPolicy.ID] = append(rv[onCall.EscalationPolicy.ID], onCall)
+		}
+
+		if !onCallsResponse.More {
+			return rv, nil
+		}
+
+		if err := nextOffset(&opts.Offset, "on-call"); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package connector
+
+import (
+	"sync"
+	"time"
+)
+
+// createdOverride is a schedule override the connector created to grant on-call.
+type createdOverride struct {
+	ID  string
+	End time.Time
+}
+
+// createdOverrides remembers the schedule overrides created by on-call grants, so that the synced on-call grants
+// point at them and revoking on-call only ever deletes an override the connector created.
+type createdOverrides struct {
+	mu sync.Mutex
+	// bySchedule maps a schedule ID to the override created for each user ID
+	bySchedule map[string]map[string]createdOverride
+}
+
+func newCreatedOverrides() *createdOverrides {
+	return &createdOverrides{
+		bySchedule: make(map[string]map[string]createdOverride),
+	}
+}
+
+// add records the override created for the user on the schedule, replacing any previous one.
+func (c *createdOverrides) add(scheduleID string, userID string, override createdOverride) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bySchedule[scheduleID] == nil {
+		c.bySchedule[scheduleID] = make(map[string]createdOverride)
+	}
+
+	c.bySchedule[scheduleID][userID] = override
+}
+
+// forUser returns the ID of the override created for the user on the schedule, unless it already ended.
+func (c *createdOverrides) forUser(scheduleID string, userID string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	override, ok := c.bySchedule[scheduleID][userID]
+	if !ok || !override.End.After(now) {
+		return "", false
+	}
+
+	return override.ID, true
+}
+
+// remove forgets the override once it was deleted.
+func (c *createdOverrides) remove(scheduleID string, userID string, overrideID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if override, ok := c.bySchedule[scheduleID][userID]; ok && override.ID == overrideID {
+		delete(c.bySchedule[scheduleID], userID)
+	}
+}
@@ -0,0 +1,185 @@
+package connector
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maxRetries is how many times an idempotent request is retried after being throttled
+	maxRetries = 5
+	// maxBackoff caps the wait between two attempts of a request
+	maxBackoff = time.Minute
+)
+
+type rateLimitCaptureKey struct{}
+
+// rateLimitCapture records the last rate limit state PagerDuty reported while serving a connector call.
+type rateLimitCapture struct {
+	mu          sync.Mutex
+	description *v2.RateLimitDescription
+}
+
+// withRateLimitCapture returns a context whose PagerDuty responses report their rate limit state to the capture.
+func withRateLimitCapture(ctx context.Context) (context.Context, *rateLimitCapture) {
+	rl := &rateLimitCapture{}
+	return context.WithValue(ctx, rateLimitCaptureKey{}, rl), rl
+}
+
+func (r *rateLimitCapture) set(description *v2.RateLimitDescription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.description = description
+}
+
+// annotations returns the rate limit annotation for the baton-sdk syncer, if PagerDuty reported any.
+func (r *rateLimitCapture) annotations() annotations.Annotations {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.description == nil {
+		return nil
+	}
+
+	annos := annotations.Annotations{}
+	annos.WithRateLimiting(r.description)
+
+	return annos
+}
+
+// rateLimitTransport retries throttled idempotent requests, waiting for the rate limit window to reset.
+type rateLimitTransport struct {
+	base http.RoundTripper
+	// after waits between two attempts, it is time.After outside of tests
+	after func(time.Duration) <-chan time.Time
+}
+
+func newRateLimitTransport(base http.RoundTripper) *rateLimitTransport {
+	return &rateLimitTransport{
+		base:  base,
+		after: time.After,
+	}
+}
+
+// rateLimitHeader returns the value of a rate limit header, PagerDuty sends them with or without the X- prefix.
+func rateLimitHeader(header http.Header, name string) (int64, bool) {
+	v := header.Get("Ratelimit-" + name)
+	if v == "" {
+		v = header.Get("X-Ratelimit-" + name)
+	}
+
+	if v == "" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// rateLimitDescription converts the rate limit response headers into a description for the baton-sdk.
+func rateLimitDescription(resp *http.Response) *v2.RateLimitDescription {
+	limit, hasLimit := rateLimitHeader(resp.Header, "Limit")
+	remaining, hasRemaining := rateLimitHeader(resp.Header, "Remaining")
+	reset, hasReset := rateLimitHeader(resp.Header, "Reset")
+
+	if !hasLimit && !hasRemaining && !hasReset && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	rl := &v2.RateLimitDescription{
+		Status:    v2.RateLimitDescription_STATUS_OK,
+		Limit:     limit,
+		Remaining: remaining,
+		ResetAt:   timestamppb.New(time.Now().Add(time.Duration(reset) * time.Second)),
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rl.Status = v2.RateLimitDescription_STATUS_OVERLIMIT
+	}
+
+	return rl
+}
+
+// retryable reports whether the request can be safely sent again after the response.
+func retryable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before the next attempt, honoring the reset advertised by PagerDuty.
+func backoff(resp *http.Response, attempt int) time.Duration {
+	wait := time.Second << attempt
+
+	if reset, ok := rateLimitHeader(resp.Header, "Reset"); ok && reset > 0 {
+		wait = time.Duration(reset) * time.Second
+	} else if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && retryAfter > 0 {
+		wait = time.Duration(retryAfter) * time.Second
+	}
+
+	wait = min(wait, maxBackoff)
+
+	// add jitter so that concurrent requests don't all retry at the same moment
+	return wait + time.Duration(rand.Int64N(int64(wait)/2+1)) // #nosec G404
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	l := ctxzap.Extract(ctx)
+	capture, _ := ctx.Value(rateLimitCaptureKey{}).(*rateLimitCapture)
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if rl := rateLimitDescription(resp); rl != nil && capture != nil {
+			capture.set(rl)
+		}
+
+		if attempt >= maxRetries || !retryable(req, resp) {
+			return resp, nil
+		}
+
+		wait := backoff(resp, attempt)
+		l.Info(
+			"pagerduty-connector: request throttled, retrying",
+			zap.String("path", req.URL.Path),
+			zap.Int("status_code", resp.StatusCode),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("wait", wait),
+		)
+
+		// the response is replaced by the next attempt
+		_ = resp.Body.Close()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.after(wait):
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package connector
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+// roundTripFunc serves requests from a function instead of the network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func testResponse(statusCode int, header map[string]string) *http.Response {
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+
+	for k, v := range header {
+		resp.Header.Set(k, v)
+	}
+
+	return resp
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		method     string
+		statusCode int
+		want       bool
+	}{
+		{method: http.MethodGet, statusCode: http.StatusTooManyRequests, want: true},
+		{method: http.MethodGet, statusCode: http.StatusBadGateway, want: true},
+		{method: http.MethodGet, statusCode: http.StatusServiceUnavailable, want: true},
+		{method: http.MethodGet, statusCode: http.StatusGatewayTimeout, want: true},
+		{method: http.MethodHead, statusCode: http.StatusTooManyRequests, want: true},
+		{method: http.MethodGet, statusCode: http.StatusOK, want: false},
+		{method: http.MethodGet, statusCode: http.StatusInternalServerError, want: false},
+		{method: http.MethodGet, statusCode: http.StatusNotFound, want: false},
+		{method: http.MethodPost, statusCode: http.StatusTooManyRequests, want: false},
+		{method: http.MethodPut, statusCode: http.StatusServiceUnavailable, want: false},
+		{method: http.MethodDelete, statusCode: http.StatusTooManyRequests, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+http.StatusText(tt.statusCode), func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, "https://api.pagerduty.com/users", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := retryable(req, testResponse(tt.statusCode, nil)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  map[string]string
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", attempt: 0, want: time.Second},
+		{name: "exponential", attempt: 3, want: 8 * time.Second},
+		{name: "capped", attempt: 10, want: maxBackoff},
+		{name: "reset", header: map[string]string{"Ratelimit-Reset": "30"}, attempt: 0, want: 30 * time.Second},
+		{name: "prefixed reset", header: map[string]string{"X-Ratelimit-Reset": "12"}, attempt: 4, want: 12 * time.Second},
+		{name: "reset capped", header: map[string]string{"Ratelimit-Reset": "600"}, want: maxBackoff},
+		{name: "retry after", header: map[string]string{"Retry-After": "7"}, attempt: 2, want: 7 * time.Second},
+		{name: "reset before retry after", header: map[string]string{"Ratelimit-Reset": "3", "Retry-After": "7"}, want: 3 * time.Second},
+		{name: "zero reset", header: map[string]string{"Ratelimit-Reset": "0"}, attempt: 1, want: 2 * time.Second},
+		{name: "invalid retry after", header: map[string]string{"Retry-After": "Wed, 21 Oct 2015 07:28:00 GMT"}, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := testResponse(http.StatusTooManyRequests, tt.header)
+
+			// the jitter adds up to half of the wait
+			for range 100 {
+				got := backoff(resp, tt.attempt)
+				if got < tt.want || got > tt.want+tt.want/2 {
+					t.Fatalf("got %v, want between %v and %v", got, tt.want, tt.want+tt.want/2)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitDescription(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		header     map[string]string
+		want       *v2.RateLimitDescription
+	}{
+		{name: "no headers", statusCode: http.StatusOK},
+		{
+			name:       "headers",
+			statusCode: http.StatusOK,
+			header:     map[string]string{"Ratelimit-Limit": "960", "Ratelimit-Remaining": "12", "Ratelimit-Reset": "30"},
+			want:       &v2.RateLimitDescription{Status: v2.RateLimitDescription_STATUS_OK, Limit: 960, Remaining: 12},
+		},
+		{
+			name:       "prefixed headers",
+			statusCode: http.StatusOK,
+			header:     map[string]string{"X-Ratelimit-Limit": "960", "X-Ratelimit-Remaining": "900"},
+			want:       &v2.RateLimitDescription{Status: v2.RateLimitDescription_STATUS_OK, Limit: 960, Remaining: 900},
+		},
+		{
+			name:       "invalid header",
+			statusCode: http.StatusOK,
+			header:     map[string]string{"Ratelimit-Limit": "lots", "Ratelimit-Remaining": "5"},
+			want:       &v2.RateLimitDescription{Status: v2.RateLimitDescription_STATUS_OK, Remaining: 5},
+		},
+		{
+			name:       "throttled without headers",
+			statusCode: http.StatusTooManyRequests,
+			want:       &v2.RateLimitDescription{Status: v2.RateLimitDescription_STATUS_OVERLIMIT},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rateLimitDescription(testResponse(tt.statusCode, tt.header))
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("expected no description, got %v", got)
+				}
+
+				return
+			}
+
+			if got == nil || got.Status != tt.want.Status || got.Limit != tt.want.Limit || got.Remaining != tt.want.Remaining {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransportRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		statusCodes  []int
+		wantAttempts int
+		wantStatus   int
+	}{
+		{name: "success", method: http.MethodGet, statusCodes: []int{http.StatusOK}, wantAttempts: 1, wantStatus: http.StatusOK},
+		{
+			name:         "throttled then success",
+			method:       http.MethodGet,
+			statusCodes:  []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusOK},
+			wantAttempts: 3,
+			wantStatus:   http.StatusOK,
+		},
+		{name: "write not retried", method: http.MethodPost, statusCodes: []int{http.StatusTooManyRequests}, wantAttempts: 1, wantStatus: http.StatusTooManyRequests},
+		{name: "max retries", method: http.MethodGet, statusCodes: []int{http.StatusTooManyRequests}, wantAttempts: maxRetries + 1, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			transport := newRateLimitTransport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+				statusCode := tt.statusCodes[min(attempts, len(tt.statusCodes)-1)]
+				attempts++
+
+				return testResponse(statusCode, map[string]string{"Ratelimit-Remaining": "0"}), nil
+			}))
+
+			var waits []time.Duration
+			transport.after = func(d time.Duration) <-chan time.Time {
+				waits = append(waits, d)
+
+				ch := make(chan time.Time, 1)
+				ch <- time.Now()
+
+				return ch
+			}
+
+			ctx, rl := withRateLimitCapture(context.Background())
+			req, err := http.NewRequestWithContext(ctx, tt.method, "https://api.pagerduty.com/users", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if attempts != tt.wantAttempts || resp.StatusCode != tt.wantStatus || len(waits) != tt.wantAttempts-1 {
+				t.Fatalf("got %d attempts, %d waits and status %d", attempts, len(waits), resp.StatusCode)
+			}
+
+			if rl.annotations() == nil {
+				t.Fatal("expected the rate limit to be captured")
+			}
+		})
+	}
+}
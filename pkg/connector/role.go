@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/PagerDuty/go-pagerduty"
@@ -14,6 +15,8 @@ import (
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -47,6 +50,13 @@ var userAccessRoles = map[string]string{
 type roleResourceType struct {
 	resourceType *v2.ResourceType
 	client       *pagerduty.Client
+	apiEndpoint  string
+	users        *userCache
+	// licenseUpgrade allows moving users onto a license allowing the granted role
+	licenseUpgrade bool
+	// fallbackRole is the base role given to users whose role is revoked, licenseFallbackRoles overrides it per license ID
+	fallbackRole         string
+	licenseFallbackRoles map[string]string
 }
 
 func (r *roleResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -102,21 +112,48 @@ func (r *roleResourceType) Entitlements(_ context.Context, resource *v2.Resource
 	return rv, "", nil, nil
 }
 
+// roleGrant creates a grant of the role membership for the user.
+func roleGrant(resource *v2.Resource, userID string) *v2.Grant {
+	return grant.NewGrant(
+		resource,
+		roleMember,
+		&v2.ResourceId{
+			ResourceType: resourceTypeUser.Id,
+			Resource:     userID,
+		},
+	)
+}
+
 func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	// PagerDuty can't filter users by role, so answer from the users listed once during this sync when possible
+	if pToken.Token == "" {
+		users, ok := r.users.usersWithRole(strings.TrimPrefix(resource.Id.Resource, "user-"))
+		if ok {
+			rv := make([]*v2.Grant, 0, len(users))
+			for _, user := range users {
+				rv = append(rv, roleGrant(resource, user.ID))
+			}
+
+			return rv, "", rl.annotations(), nil
+		}
+
+		// the users weren't all listed yet, this listing fills the cache for the other roles
+		r.users.reset()
+	}
+
 	// Handle pagination
-	bag, page, err := parsePageToken(pToken.Token, resource.Id)
+	bag, cursor, err := parsePageToken(pToken.Token, resource.Id)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	paginationOpts := pagerduty.ListUsersOptions{
 		Limit:  ResourcesPageSize,
-		Offset: page,
-	}
-
-	nextPage, err := handleNextPage(bag, page+ResourcesPageSize)
-	if err != nil {
-		return nil, "", nil, err
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
 	}
 
 	usersResponse, err := r.client.ListUsersWithContext(ctx, paginationOpts)
@@ -124,6 +161,8 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list users: %w", err)
 	}
 
+	r.users.add(usersResponse.Users)
+
 	var rv []*v2.Grant
 	for _, user := range usersResponse.Users {
 		userRole := fmt.Sprintf("user-%s", user.Role)
@@ -132,23 +171,23 @@ func (r *roleResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 			continue
 		}
 
-		uID, err := rs.NewResourceID(resourceTypeUser, user.ID)
-		if err != nil {
-			return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to create user resource id: %w", err)
-		}
+		rv = append(rv, roleGrant(resource, user.ID))
+	}
 
-		rv = append(rv, grant.NewGrant(
-			resource,
-			roleMember,
-			uID,
-		))
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  usersResponse.More,
+		Total: usersResponse.Total,
+		Names: listedNames(usersResponse.Users, func(user pagerduty.User) string { return user.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	if usersResponse.More {
-		return rv, nextPage, nil, nil
+	if pageToken == "" {
+		r.users.markComplete()
 	}
 
-	return rv, "", nil, nil
+	return rv, pageToken, rl.annotations(), nil
 }
 
 func (r *roleResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
@@ -164,16 +203,36 @@ func (r *roleResourceType) Grant(ctx context.Context, principal *v2.Resource, en
 		return nil, fmt.Errorf("pagerduty-connector: only users can be granted role")
 	}
 
-	user, err := r.client.GetUserWithContext(
-		ctx,
-		principal.Id.Resource,
-		pagerduty.GetUserOptions{},
-	)
+	user, err := r.users.refresh(ctx, principal.Id.Resource)
 	if err != nil {
-		return nil, fmt.Errorf("pagerduty-connector: failed to get user: %w", err)
+		return nil, err
 	}
 
 	roleId := strings.TrimPrefix(entitlement.Resource.Id.Resource, "user-")
+
+	// the license of the user restricts the base roles it can have
+	license, err := r.licenseForRole(ctx, user.ID, roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	if license != nil {
+		l.Info(
+			"pagerduty-connector: moving user to a license allowing the role",
+			zap.String("principal_id", user.ID),
+			zap.String("role", roleId),
+			zap.String("license_id", license.ID),
+		)
+
+		// role and license change together, the user can't hold the role under its current license
+		err = updateUserLicense(ctx, r.client, r.apiEndpoint, user.ID, license.ID, roleId)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to grant role %s with license %s: %w", roleId, license.Name, err)
+		}
+
+		return nil, nil
+	}
+
 	user.Role = roleId
 
 	// grant role membership
@@ -204,18 +263,48 @@ func (r *roleResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annotat
 		return nil, fmt.Errorf("pagerduty-connector: only users can have role revoked")
 	}
 
-	user, err := r.client.GetUserWithContext(
-		ctx,
-		principal.Id.Resource,
-		pagerduty.GetUserOptions{},
-	)
+	roleId := strings.TrimPrefix(entitlement.Resource.Id.Resource, "user-")
+
+	// the account must always have an owner, ownership is transferred by granting the role to another user
+	if roleId == roleOwner {
+		return nil, status.Error(
+			codes.FailedPrecondition,
+			"pagerduty-connector: owner role can't be revoked, transfer the account ownership to another user instead",
+		)
+	}
+
+	user, err := r.users.refresh(ctx, principal.Id.Resource)
 	if err != nil {
-		return nil, fmt.Errorf("pagerduty-connector: failed to get user: %w", err)
+		return nil, err
 	}
 
-	// since user have to have at least one role, we reset it to limited_user
-	roleId := "limited_user"
-	user.Role = roleId
+	// the grant may be stale, the user must not lose a role it was given since
+	if user.Role != roleId {
+		l.Info(
+			"pagerduty-connector: user no longer has the role",
+			zap.String("principal_id", user.ID),
+			zap.String("role", roleId),
+			zap.String("current_role", user.Role),
+		)
+
+		return nil, nil
+	}
+
+	// since user have to have at least one role, we reset it to the fallback role
+	fallbackRole, err := r.fallbackRoleFor(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if fallbackRole == roleId {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: role %s is the fallback role of the user, grant another role instead",
+			roleId,
+		)
+	}
+
+	user.Role = fallbackRole
 
 	// revoke role
 	_, err = r.client.UpdateUserWithContext(
@@ -229,9 +318,105 @@ func (r *roleResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annotat
 	return nil, nil
 }
 
-func roleBuilder(client *pagerduty.Client) *roleResourceType {
+// fallbackRoleFor returns the role given to the user when its role is revoked, checking that its license allows it.
+func (r *roleResourceType) fallbackRoleFor(ctx context.Context, userID string) (string, error) {
+	license, licensed, err := getUserLicense(ctx, r.client, r.apiEndpoint, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if !licensed || license == nil {
+		return r.fallbackRole, nil
+	}
+
+	fallbackRole, ok := r.licenseFallbackRoles[license.ID]
+	if !ok {
+		fallbackRole = r.fallbackRole
+	}
+
+	if !slices.Contains(license.ValidRoles, fallbackRole) {
+		return "", status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: fallback role %s is not allowed by the %s license of the user, map the license to one of: %s",
+			fallbackRole,
+			license.Name,
+			strings.Join(license.ValidRoles, ", "),
+		)
+	}
+
+	return fallbackRole, nil
+}
+
+// licenseForRole checks that the license of the user allows the role. When it doesn't, it returns the license
+// the user must be moved to, or a FailedPrecondition error if license upgrades are disabled or no license fits.
+func (r *roleResourceType) licenseForRole(ctx context.Context, userID string, role string) (*pagerduty.License, error) {
+	current, licensed, err := getUserLicense(ctx, r.client, r.apiEndpoint, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !licensed || (current != nil && slices.Contains(current.ValidRoles, role)) {
+		return nil, nil
+	}
+
+	currentName := "no"
+	if current != nil {
+		currentName = current.Name
+	}
+
+	if !r.licenseUpgrade {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: role %s is not allowed by the %s license of the user, grant a license allowing it first",
+			role,
+			currentName,
+		)
+	}
+
+	licensesResponse, err := r.client.ListLicensesWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to list licenses: %w", err)
+	}
+
+	var rv *pagerduty.License
+	for _, license := range licensesResponse.Licenses {
+		if license.AllocationsAvailable <= 0 || !slices.Contains(license.ValidRoles, role) {
+			continue
+		}
+
+		// prefer a license of the same role group, e.g. moving between full user licenses
+		if rv == nil || (current != nil && license.RoleGroup == current.RoleGroup && rv.RoleGroup != current.RoleGroup) {
+			rv = &license // #nosec G601
+		}
+	}
+
+	if rv == nil {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: role %s is not allowed by the %s license of the user, and no license allowing it has allocations available",
+			role,
+			currentName,
+		)
+	}
+
+	return rv, nil
+}
+
+func roleBuilder(
+	client *pagerduty.Client,
+	apiEndpoint string,
+	users *userCache,
+	licenseUpgrade bool,
+	fallbackRole string,
+	licenseFallbackRoles map[string]string,
+) *roleResourceType {
 	return &roleResourceType{
-		resourceType: resourceTypeRole,
-		client:       client,
+		resourceType:         resourceTypeRole,
+		client:               client,
+		apiEndpoint:          apiEndpoint,
+		users:                users,
+		licenseUpgrade:       licenseUpgrade,
+		fallbackRole:         fallbackRole,
+		licenseFallbackRoles: licenseFallbackRoles,
 	}
 }
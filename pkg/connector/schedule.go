@@ -2,7 +2,10 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
@@ -13,16 +16,38 @@ import (
 	"github.com/conductorone/baton-sdk/pkg/types/grant"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	scheduleMember = "member"
 	scheduleOnCall = "on-call"
+	// scheduleUpcomingOnCall covers shifts starting within the on-call lookahead window
+	scheduleUpcomingOnCall = "upcoming-on-call"
+
+	userReference = "user_reference"
+
+	// overrideRevokeWindow bounds how far in the future overrides of a deleted user are looked up
+	overrideRevokeWindow = 90 * 24 * time.Hour
+	// overrideIDKey is the grant metadata key holding the ID of the override an on-call grant created
+	overrideIDKey = "override_id"
+	// overrideDurationKey is the grant metadata key of the on-call grant request setting how long the override lasts
+	overrideDurationKey = "duration"
 )
 
 type scheduleResourceType struct {
 	resourceType *v2.ResourceType
 	client       *pagerduty.Client
+	// layerPattern selects the layer users are added to, the last layer is used if nil or nothing matches
+	layerPattern *regexp.Regexp
+	// overrideDuration is how long the on-call schedule override created by a grant lasts, unless the grant sets it
+	overrideDuration time.Duration
+	// overrides are the schedule overrides created by on-call grants
+	overrides *createdOverrides
+	// onCallLookahead is how far in the future on-call shifts are synced
+	onCallLookahead time.Duration
 }
 
 func (s *scheduleResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -50,6 +75,7 @@ func scheduleResource(schedule *pagerduty.Schedule) (*v2.Resource, error) {
 		resourceTypeSchedule,
 		schedule.ID,
 		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: resourceTypeScheduleLayer.Id}),
 	)
 	if err != nil {
 		return nil, err
@@ -59,19 +85,18 @@ func scheduleResource(schedule *pagerduty.Schedule) (*v2.Resource, error) {
 }
 
 func (s *scheduleResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	bag, page, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeSchedule.Id})
+	ctx, rl := withRateLimitCapture(ctx)
+
+	bag, cursor, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeSchedule.Id})
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	paginationOpts := pagerduty.ListSchedulesOptions{
 		Limit:  ResourcesPageSize,
-		Offset: page,
-	}
-
-	pageToken, err := handleNextPage(bag, page+ResourcesPageSize)
-	if err != nil {
-		return nil, "", nil, err
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
 	}
 
 	schedulesResponse, err := s.client.ListSchedulesWithContext(ctx, paginationOpts)
@@ -89,18 +114,23 @@ func (s *scheduleResourceType) List(ctx context.Context, parentID *v2.ResourceId
 		rv = append(rv, sr)
 	}
 
-	if schedulesResponse.More {
-		return rv, pageToken, nil, nil
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  schedulesResponse.More,
+		Total: schedulesResponse.Total,
+		Names: listedNames(schedulesResponse.Schedules, func(schedule pagerduty.Schedule) string { return schedule.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	return rv, "", nil, nil
+	return rv, pageToken, rl.annotations(), nil
 }
 
 func (s *scheduleResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
 	var rv []*v2.Entitlement
 
 	memberEntitlementOptions := []ent.EntitlementOption{
-		ent.WithGrantableTo(resourceTypeUser, resourceTypeTeam),
+		ent.WithGrantableTo(resourceTypeUser, resourceTypeTeam, resourceTypeScheduleLayer),
 		ent.WithDisplayName(fmt.Sprintf("%s schedule %s", resource.DisplayName, scheduleMember)),
 		ent.WithDescription(fmt.Sprintf("%s PagerDuty schedule %s", resource.DisplayName, scheduleMember)),
 	}
@@ -108,46 +138,65 @@ func (s *scheduleResourceType) Entitlements(_ context.Context, resource *v2.Reso
 	oncallEntitlementOptions := []ent.EntitlementOption{
 		ent.WithGrantableTo(resourceTypeUser),
 		ent.WithDisplayName(fmt.Sprintf("%s schedule %s", resource.DisplayName, scheduleOnCall)),
-		ent.WithDescription(fmt.Sprintf("%s PagerDuty schedule %s", resource.DisplayName, scheduleOnCall)),
+		ent.WithDescription(fmt.Sprintf(
+			"%s PagerDuty schedule %s, granted through a schedule override, revoked by deleting the override the grant created",
+			resource.DisplayName,
+			scheduleOnCall,
+		)),
+	}
+
+	upcomingOncallEntitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeUser),
+		ent.WithDisplayName(fmt.Sprintf("%s schedule %s", resource.DisplayName, scheduleUpcomingOnCall)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty schedule %s, shifts starting in the on-call lookahead window", resource.DisplayName, scheduleUpcomingOnCall)),
 	}
 
 	rv = append(
 		rv,
 		ent.NewAssignmentEntitlement(resource, scheduleMember, memberEntitlementOptions...),
 		ent.NewAssignmentEntitlement(resource, scheduleOnCall, oncallEntitlementOptions...),
+		ent.NewAssignmentEntitlement(resource, scheduleUpcomingOnCall, upcomingOncallEntitlementOptions...),
 	)
 
 	return rv, "", nil, nil
 }
 
 func (s *scheduleResourceType) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
 	l := ctxzap.Extract(ctx)
 
-	// parse resource profile to get schedule members (users or teams) and grant them the member entitlement
+	// parse resource profile to get schedule teams and grant them the member entitlement
 	groupTrait, err := rs.GetGroupTrait(resource)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
-	users, ok := getProfileStringArray(groupTrait.Profile, "schedule_users")
-	if !ok {
-		l.Info("pager-duty-connector: no users found for schedule resource")
-	}
-
 	teams, ok := getProfileStringArray(groupTrait.Profile, "schedule_teams")
 	if !ok {
 		l.Info("pager-duty-connector: no teams found for schedule resource")
 	}
 
+	schedule, err := s.client.GetScheduleWithContext(ctx, resource.Id.Resource, pagerduty.GetScheduleOptions{})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to get schedule: %w", err)
+	}
+
+	// users are members of the schedule through the layers they rotate in
 	var rv []*v2.Grant
-	for _, u := range users {
+	for _, layer := range schedule.ScheduleLayers {
 		rv = append(rv, grant.NewGrant(
 			resource,
 			scheduleMember,
 			&v2.ResourceId{
-				ResourceType: resourceTypeUser.Id,
-				Resource:     u,
+				ResourceType: resourceTypeScheduleLayer.Id,
+				Resource:     layer.ID,
 			},
+			grant.WithAnnotation(
+				&v2.GrantExpandable{
+					EntitlementIds: []string{fmt.Sprintf("schedule_layer:%s:%s", layer.ID, scheduleLayerMember)},
+				},
+			),
 		))
 	}
 
@@ -169,38 +218,449 @@ func (s *scheduleResourceType) Grants(ctx context.Context, resource *v2.Resource
 
 	// Only UTC format is supported by PagerDuty
 	now := time.Now().UTC()
-	hourFromNow := now.Add(time.Hour)
-
-	// go through all users on call for this schedule resource and grant them the on-call entitlement
-	usersResponse, err := s.client.ListOnCallUsersWithContext(
-		ctx,
-		resource.Id.Resource,
-		pagerduty.ListOnCallUsersOptions{
-			Since: now.Format(time.RFC3339),
-			Until: hourFromNow.Format(time.RFC3339),
-		},
-	)
+
+	onCalls, err := s.listOnCalls(ctx, resource.Id.Resource, now)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list on-call users: %w", err)
+		return nil, "", nil, err
+	}
+
+	// a user can have several shifts in the window (one per escalation level using the schedule), keep the earliest one
+	current, upcoming := make(map[string]pagerduty.OnCall), make(map[string]pagerduty.OnCall)
+	for _, onCall := range onCalls {
+		shifts := current
+		if start, err := time.Parse(time.RFC3339, onCall.Start); err == nil && start.After(now) {
+			shifts = upcoming
+		}
+
+		if existing, ok := shifts[onCall.User.ID]; !ok || onCall.Start < existing.Start {
+			shifts[onCall.User.ID] = onCall
+		}
 	}
 
-	for _, user := range usersResponse {
+	rv = append(rv, s.onCallGrants(resource, scheduleOnCall, current, now)...)
+	rv = append(rv, s.onCallGrants(resource, scheduleUpcomingOnCall, upcoming, now)...)
+
+	return rv, "", rl.annotations(), nil
+}
+
+// onCallGrants creates a grant of the entitlement for every user holding one of the shifts, pointing at the override
+// the connector created for the user when there is one.
+func (s *scheduleResourceType) onCallGrants(resource *v2.Resource, entitlementName string, shifts map[string]pagerduty.OnCall, now time.Time) []*v2.Grant {
+	rv := make([]*v2.Grant, 0, len(shifts))
+	for userID, onCall := range shifts {
+		metadata := onCallShiftMetadata(&onCall) // #nosec G601
+		if overrideID, ok := s.overrides.forUser(resource.Id.Resource, userID, now); ok && entitlementName == scheduleOnCall {
+			metadata[overrideIDKey] = overrideID
+		}
+
 		rv = append(rv, grant.NewGrant(
 			resource,
-			scheduleOnCall,
+			entitlementName,
 			&v2.ResourceId{
 				ResourceType: resourceTypeUser.Id,
-				Resource:     user.ID,
+				Resource:     userID,
 			},
+			grant.WithGrantMetadata(metadata),
 		))
 	}
 
-	return rv, "", nil, nil
+	return rv
+}
+
+// onCallShiftMetadata describes the on-call shift a grant was derived from.
+func onCallShiftMetadata(onCall *pagerduty.OnCall) map[string]interface{} {
+	return map[string]interface{}{
+		"shift_start":          onCall.Start,
+		"shift_end":            onCall.End,
+		"escalation_policy_id": onCall.EscalationPolicy.ID,
+		"escalation_level":     int(onCall.EscalationLevel),
+	}
+}
+
+// listOnCalls returns every on-call shift of the schedule overlapping the lookahead window.
+func (s *scheduleResourceType) listOnCalls(ctx context.Context, scheduleID string, now time.Time) ([]pagerduty.OnCall, error) {
+	opts := pagerduty.ListOnCallOptions{
+		Limit:       ResourcesPageSize,
+		ScheduleIDs: []string{scheduleID},
+		TimeZone:    "UTC",
+	}
+
+	// without a window PagerDuty returns the shifts active right now
+	if s.onCallLookahead > 0 {
+		opts.Since = now.Format(time.RFC3339)
+		opts.Until = now.Add(s.onCallLookahead).Format(time.RFC3339)
+	}
+
+	var rv []pagerduty.OnCall
+	for {
+		onCallsResponse, err := s.client.ListOnCallsWithContext(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to list on-calls: %w", err)
+		}
+
+		rv = append(rv, onCallsResponse.OnCalls...)
+
+		if !onCallsResponse.More {
+			return rv, nil
+		}
+
+		if err := nextOffset(&opts.Offset, "on-call"); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// grantLayer returns the index of the schedule layer new members are added to.
+func (s *scheduleResourceType) grantLayer(ctx context.Context, schedule *pagerduty.Schedule) int {
+	l := ctxzap.Extract(ctx)
+
+	if s.layerPattern != nil {
+		for i, layer := range schedule.ScheduleLayers {
+			if s.layerPattern.MatchString(layer.Name) {
+				return i
+			}
+		}
+
+		l.Info(
+			"pagerduty-connector: no schedule layer matches the configured pattern, using the last layer",
+			zap.String("schedule_id", schedule.ID),
+			zap.String("pattern", s.layerPattern.String()),
+		)
+	}
+
+	return len(schedule.ScheduleLayers) - 1
+}
+
+// updateScheduleLayers pushes the schedule layers back to PagerDuty leaving the rest of the schedule untouched.
+func updateScheduleLayers(ctx context.Context, client *pagerduty.Client, schedule *pagerduty.Schedule) error {
+	layers := make([]pagerduty.ScheduleLayer, 0, len(schedule.ScheduleLayers))
+	for _, layer := range schedule.ScheduleLayers {
+		// rendered entries are computed by PagerDuty and can't be updated
+		layer.RenderedScheduleEntries = nil
+		layers = append(layers, layer)
+	}
+
+	_, err := client.UpdateScheduleWithContext(ctx, schedule.ID, pagerduty.Schedule{
+		Name:           schedule.Name,
+		TimeZone:       schedule.TimeZone,
+		Description:    schedule.Description,
+		ScheduleLayers: layers,
+	})
+
+	return err
+}
+
+func (s *scheduleResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if principal.Id.ResourceType != resourceTypeUser.Id {
+		l.Warn(
+			"pagerduty-connector: only users can be granted schedule membership or on-call",
+			zap.String("principal_id", principal.Id.Resource),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, nil, fmt.Errorf("pagerduty-connector: only users can be granted schedule membership or on-call")
+	}
+
+	scheduleID, userID := entitlement.Resource.Id.Resource, principal.Id.Resource
+
+	switch entitlement.Slug {
+	case scheduleMember:
+		annos, err := s.grantMembership(ctx, scheduleID, userID)
+		return nil, annos, err
+	case scheduleOnCall:
+		duration, err := s.onCallDuration(entitlement)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return s.grantOnCall(ctx, entitlement.Resource, userID, duration)
+	default:
+		return nil, nil, fmt.Errorf("pagerduty-connector: unsupported schedule entitlement %s", entitlement.Slug)
+	}
+}
+
+func (s *scheduleResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	entitlement := grant.Entitlement
+	principal := grant.Principal
+
+	if principal.Id.ResourceType != resourceTypeUser.Id {
+		l.Warn(
+			"pagerduty-connector: only users can have schedule membership or on-call revoked",
+			zap.String("principal_id", principal.Id.Resource),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, fmt.Errorf("pagerduty-connector: only users can have schedule membership or on-call revoked")
+	}
+
+	scheduleID, userID := entitlement.Resource.Id.Resource, principal.Id.Resource
+
+	switch entitlement.Slug {
+	case scheduleMember:
+		return s.revokeMembership(ctx, scheduleID, userID)
+	case scheduleOnCall:
+		return s.revokeOnCall(ctx, scheduleID, userID, grant)
+	default:
+		return nil, fmt.Errorf("pagerduty-connector: unsupported schedule entitlement %s", entitlement.Slug)
+	}
+}
+
+// grantMembership adds the user to the rotation of the configured schedule layer.
+func (s *scheduleResourceType) grantMembership(ctx context.Context, scheduleID string, userID string) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	schedule, err := s.client.GetScheduleWithContext(ctx, scheduleID, pagerduty.GetScheduleOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to get schedule: %w", err)
+	}
+
+	if len(schedule.ScheduleLayers) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "pagerduty-connector: schedule %s has no layers to add the user to", schedule.Name)
+	}
+
+	for _, layer := range schedule.ScheduleLayers {
+		for _, u := range layer.Users {
+			if u.User.ID == userID {
+				l.Info(
+					"pagerduty-connector: user is already a member of the schedule",
+					zap.String("principal_id", userID),
+					zap.String("schedule_layer_id", layer.ID),
+				)
+
+				return nil, nil
+			}
+		}
+	}
+
+	// new members join at the end of the rotation
+	idx := s.grantLayer(ctx, schedule)
+	schedule.ScheduleLayers[idx].Users = append(schedule.ScheduleLayers[idx].Users, pagerduty.UserReference{
+		User: pagerduty.APIObject{
+			ID:   userID,
+			Type: userReference,
+		},
+	})
+
+	// grant schedule membership
+	err = updateScheduleLayers(ctx, s.client, schedule)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to grant schedule membership: %w", err)
+	}
+
+	return nil, nil
+}
+
+// revokeMembership removes the user from every layer of the schedule.
+func (s *scheduleResourceType) revokeMembership(ctx context.Context, scheduleID string, userID string) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	schedule, err := s.client.GetScheduleWithContext(ctx, scheduleID, pagerduty.GetScheduleOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to get schedule: %w", err)
+	}
+
+	removed := false
+	for i, layer := range schedule.ScheduleLayers {
+		// keep the rotation order of everyone else
+		users := make([]pagerduty.UserReference, 0, len(layer.Users))
+		for _, u := range layer.Users {
+			if u.User.ID == userID {
+				continue
+			}
+
+			users = append(users, u)
+		}
+
+		if len(users) == len(layer.Users) {
+			continue
+		}
+
+		// PagerDuty rejects schedule layers without any user
+		if len(users) == 0 {
+			return nil, status.Errorf(
+				codes.FailedPrecondition,
+				"pagerduty-connector: cannot revoke %s from schedule %s: it is the only user of layer %s",
+				userID,
+				schedule.Name,
+				layer.Name,
+			)
+		}
+
+		schedule.ScheduleLayers[i].Users = users
+		removed = true
+	}
+
+	if !removed {
+		l.Info(
+			"pagerduty-connector: user is not a member of the schedule",
+			zap.String("principal_id", userID),
+			zap.String("schedule_id", schedule.ID),
+		)
+
+		return nil, nil
+	}
+
+	// revoke schedule membership
+	err = updateScheduleLayers(ctx, s.client, schedule)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to revoke schedule membership: %w", err)
+	}
+
+	return nil, nil
+}
+
+// onCallDuration returns how long the override granting on-call lasts, read from the duration of the grant
+// metadata of the requested entitlement, e.g. "4h", and the configured default otherwise.
+func (s *scheduleResourceType) onCallDuration(entitlement *v2.Entitlement) (time.Duration, error) {
+	metadata := &v2.GrantMetadata{}
+	annos := annotations.Annotations(entitlement.Annotations)
+	ok, err := annos.Pick(metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	value := metadata.GetMetadata().GetFields()[overrideDurationKey].GetStringValue()
+	if !ok || value == "" {
+		return s.overrideDuration, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "pagerduty-connector: invalid on-call duration %q, expected a positive duration such as 4h", value)
+	}
+
+	return duration, nil
+}
+
+// grantOnCall puts the user on-call by creating a schedule override starting now, and returns the grant pointing at it.
+func (s *scheduleResourceType) grantOnCall(ctx context.Context, schedule *v2.Resource, userID string, duration time.Duration) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	// Only UTC format is supported by PagerDuty
+	now := time.Now().UTC()
+	end := now.Add(duration)
+
+	override, err := s.client.CreateOverrideWithContext(ctx, schedule.Id.Resource, pagerduty.Override{
+		Start: now.Format(time.RFC3339),
+		End:   end.Format(time.RFC3339),
+		User: pagerduty.APIObject{
+			ID:   userID,
+			Type: userReference,
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("pagerduty-connector: failed to grant on-call: %w", err)
+	}
+
+	s.overrides.add(schedule.Id.Resource, userID, createdOverride{ID: override.ID, End: end})
+
+	l.Info(
+		"pagerduty-connector: created on-call schedule override",
+		zap.String("principal_id", userID),
+		zap.String("schedule_id", schedule.Id.Resource),
+		zap.String("override_id", override.ID),
+		zap.String("start", override.Start),
+		zap.String("end", override.End),
+	)
+
+	rv := grant.NewGrant(
+		schedule,
+		scheduleOnCall,
+		&v2.ResourceId{
+			ResourceType: resourceTypeUser.Id,
+			Resource:     userID,
+		},
+		grant.WithGrantMetadata(map[string]interface{}{
+			overrideIDKey: override.ID,
+			"shift_start": override.Start,
+			"shift_end":   override.End,
+		}),
+	)
+
+	return []*v2.Grant{rv}, nil, nil
+}
+
+// revokeOnCall deletes the schedule override the on-call grant created. Overrides created in PagerDuty directly and
+// on-call shifts of the rotation are left alone, their revocation is refused instead of reporting a success the next
+// sync would undo.
+func (s *scheduleResourceType) revokeOnCall(ctx context.Context, scheduleID string, userID string, onCallGrant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	overrideID, err := grantOverrideID(onCallGrant)
+	if err != nil {
+		return nil, err
+	}
+
+	if overrideID == "" {
+		overrideID, _ = s.overrides.forUser(scheduleID, userID, time.Now())
+	}
+
+	if overrideID == "" {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: on-call of user %s on schedule %s doesn't come from an override created by the connector, "+
+				"delete the override in PagerDuty or revoke the schedule membership instead",
+			userID,
+			scheduleID,
+		)
+	}
+
+	// deleting an active override truncates it to end now
+	err = s.client.DeleteOverrideWithContext(ctx, scheduleID, overrideID)
+	if err != nil {
+		var apiErr pagerduty.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			return nil, fmt.Errorf("pagerduty-connector: failed to revoke on-call: %w", err)
+		}
+
+		l.Info(
+			"pagerduty-connector: on-call schedule override already deleted",
+			zap.String("principal_id", userID),
+			zap.String("schedule_id", scheduleID),
+			zap.String("override_id", overrideID),
+		)
+	} else {
+		l.Info(
+			"pagerduty-connector: deleted on-call schedule override",
+			zap.String("principal_id", userID),
+			zap.String("schedule_id", scheduleID),
+			zap.String("override_id", overrideID),
+		)
+	}
+
+	s.overrides.remove(scheduleID, userID, overrideID)
+
+	return nil, nil
+}
+
+// grantOverrideID returns the ID of the override an on-call grant points at, empty when it has none.
+func grantOverrideID(g *v2.Grant) (string, error) {
+	metadata := &v2.GrantMetadata{}
+	annos := annotations.Annotations(g.Annotations)
+	if _, err := annos.Pick(metadata); err != nil {
+		return "", err
+	}
+
+	return metadata.GetMetadata().GetFields()[overrideIDKey].GetStringValue(), nil
 }
 
-func scheduleBuilder(client *pagerduty.Client) *scheduleResourceType {
+func scheduleBuilder(
+	client *pagerduty.Client,
+	layerPattern *regexp.Regexp,
+	overrideDuration time.Duration,
+	onCallLookahead time.Duration,
+	overrides *createdOverrides,
+) *scheduleResourceType {
 	return &scheduleResourceType{
-		resourceType: resourceTypeSchedule,
-		client:       client,
+		resourceType:     resourceTypeSchedule,
+		client:           client,
+		layerPattern:     layerPattern,
+		overrideDuration: overrideDuration,
+		onCallLookahead:  onCallLookahead,
+		overrides:        overrides,
 	}
 }
@@ -0,0 +1,155 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+)
+
+const (
+	scheduleLayerMember = "member"
+)
+
+type scheduleLayerResourceType struct {
+	resourceType *v2.ResourceType
+	client       *pagerduty.Client
+}
+
+func (s *scheduleLayerResourceType) ResourceType(_ context.Context) *v2.ResourceType {
+	return s.resourceType
+}
+
+// scheduleLayerRestrictions formats layer restrictions into human readable strings.
+func scheduleLayerRestrictions(restrictions []pagerduty.Restriction) []interface{} {
+	rv := make([]interface{}, 0, len(restrictions))
+	for _, r := range restrictions {
+		if r.StartDayOfWeek != 0 {
+			rv = append(rv, fmt.Sprintf("%s: day %d from %s for %ds", r.Type, r.StartDayOfWeek, r.StartTimeOfDay, r.DurationSeconds))
+			continue
+		}
+
+		rv = append(rv, fmt.Sprintf("%s: from %s for %ds", r.Type, r.StartTimeOfDay, r.DurationSeconds))
+	}
+
+	return rv
+}
+
+// scheduleLayerResource creates a new connector resource for a PagerDuty Schedule Layer.
+func scheduleLayerResource(schedule *pagerduty.Schedule, layer *pagerduty.ScheduleLayer, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	// layer names (e.g. "Layer 1") are only unique within their schedule
+	displayName := fmt.Sprintf("%s %s", schedule.Name, layer.Name)
+
+	users := make([]interface{}, 0, len(layer.Users))
+	for _, u := range layer.Users {
+		users = append(users, u.User.ID)
+	}
+
+	profile := map[string]interface{}{
+		"schedule_layer_id":            layer.ID,
+		"schedule_layer_name":          layer.Name,
+		"schedule_id":                  parentResourceID.Resource,
+		"start":                        layer.Start,
+		"end":                          layer.End,
+		"rotation_virtual_start":       layer.RotationVirtualStart,
+		"rotation_turn_length_seconds": int(layer.RotationTurnLengthSeconds),
+		"restrictions":                 scheduleLayerRestrictions(layer.Restrictions),
+		"schedule_layer_users":         users,
+	}
+
+	resource, err := rs.NewGroupResource(
+		displayName,
+		resourceTypeScheduleLayer,
+		layer.ID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (s *scheduleLayerResourceType) List(ctx context.Context, parentID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	// schedule layers are only listed as children of a schedule
+	if parentID == nil {
+		return nil, "", nil, nil
+	}
+
+	schedule, err := s.client.GetScheduleWithContext(ctx, parentID.Resource, pagerduty.GetScheduleOptions{})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to get schedule: %w", err)
+	}
+
+	rv := make([]*v2.Resource, 0, len(schedule.ScheduleLayers))
+	for _, layer := range schedule.ScheduleLayers {
+		lr, err := scheduleLayerResource(schedule, &layer, parentID) // #nosec G601
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, lr)
+	}
+
+	return rv, "", rl.annotations(), nil
+}
+
+func (s *scheduleLayerResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	memberEntitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeUser),
+		ent.WithDisplayName(fmt.Sprintf("%s schedule layer %s", resource.DisplayName, scheduleLayerMember)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty schedule layer %s", resource.DisplayName, scheduleLayerMember)),
+	}
+
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, scheduleLayerMember, memberEntitlementOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (s *scheduleLayerResourceType) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	// parse resource profile to get users in the rotation of this layer
+	groupTrait, err := rs.GetGroupTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	users, ok := getProfileStringArray(groupTrait.Profile, "schedule_layer_users")
+	if !ok {
+		l.Info("pager-duty-connector: no users found for schedule layer resource")
+	}
+
+	var rv []*v2.Grant
+	for _, u := range users {
+		rv = append(rv, grant.NewGrant(
+			resource,
+			scheduleLayerMember,
+			&v2.ResourceId{
+				ResourceType: resourceTypeUser.Id,
+				Resource:     u,
+			},
+		))
+	}
+
+	return rv, "", nil, nil
+}
+
+func scheduleLayerBuilder(client *pagerduty.Client) *scheduleLayerResourceType {
+	return &scheduleLayerResourceType{
+		resourceType: resourceTypeScheduleLayer,
+		client:       client,
+	}
+}
@@ -0,0 +1,111 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func entitlementWithMetadata(t *testing.T, metadata map[string]interface{}) *v2.Entitlement {
+	t.Helper()
+
+	entitlement := &v2.Entitlement{Slug: scheduleOnCall}
+	if metadata == nil {
+		return entitlement
+	}
+
+	md, err := structpb.NewStruct(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var annos annotations.Annotations
+	annos.Update(&v2.GrantMetadata{Metadata: md})
+	entitlement.Annotations = annos
+
+	return entitlement
+}
+
+func TestOnCallDuration(t *testing.T) {
+	s := &scheduleResourceType{overrideDuration: time.Hour}
+
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		want     time.Duration
+		wantCode codes.Code
+	}{
+		{name: "default", want: time.Hour},
+		{name: "no duration", metadata: map[string]interface{}{"reason": "incident"}, want: time.Hour},
+		{name: "grant duration", metadata: map[string]interface{}{overrideDurationKey: "4h30m"}, want: 4*time.Hour + 30*time.Minute},
+		{name: "invalid", metadata: map[string]interface{}{overrideDurationKey: "soon"}, wantCode: codes.InvalidArgument},
+		{name: "negative", metadata: map[string]interface{}{overrideDurationKey: "-1h"}, wantCode: codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.onCallDuration(entitlementWithMetadata(t, tt.metadata))
+			if tt.wantCode != codes.OK {
+				if status.Code(err) != tt.wantCode {
+					t.Fatalf("expected %v, got %v", tt.wantCode, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnCallGrantsPointAtCreatedOverrides(t *testing.T) {
+	now := time.Now()
+	s := &scheduleResourceType{overrides: newCreatedOverrides()}
+	s.overrides.add("PSCHED", "PUSER1", createdOverride{ID: "POVERRIDE", End: now.Add(time.Hour)})
+	s.overrides.add("PSCHED", "PUSER2", createdOverride{ID: "PEXPIRED", End: now.Add(-time.Minute)})
+
+	resource := &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeSchedule.Id, Resource: "PSCHED"}}
+	shifts := map[string]pagerduty.OnCall{
+		"PUSER1": {},
+		"PUSER2": {},
+		"PUSER3": {},
+	}
+
+	want := map[string]string{"PUSER1": "POVERRIDE"}
+	for _, g := range s.onCallGrants(resource, scheduleOnCall, shifts, now) {
+		overrideID, err := grantOverrideID(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if overrideID != want[g.Principal.Id.Resource] {
+			t.Fatalf("user %s: got override %q, want %q", g.Principal.Id.Resource, overrideID, want[g.Principal.Id.Resource])
+		}
+	}
+}
+
+func TestGrantOverrideIDWithoutMetadata(t *testing.T) {
+	g := grant.NewGrant(
+		&v2.Resource{Id: &v2.ResourceId{ResourceType: resourceTypeSchedule.Id, Resource: "PSCHED"}},
+		scheduleOnCall,
+		&v2.ResourceId{ResourceType: resourceTypeUser.Id, Resource: "PUSER"},
+	)
+
+	overrideID, err := grantOverrideID(g)
+	if err != nil || overrideID != "" {
+		t.Fatalf("expected no override, got %q, error %v", overrideID, err)
+	}
+}
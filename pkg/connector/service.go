@@ -0,0 +1,203 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+)
+
+const (
+	serviceOwner     = "owner"
+	serviceResponder = "responder"
+
+	includeEscalationPolicies = "escalation_policies"
+)
+
+type serviceResourceType struct {
+	resourceType *v2.ResourceType
+	client       *pagerduty.Client
+}
+
+func (s *serviceResourceType) ResourceType(_ context.Context) *v2.ResourceType {
+	return s.resourceType
+}
+
+// serviceResource creates a new connector resource for a PagerDuty Technical Service.
+func serviceResource(service *pagerduty.Service) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"service_id":     service.ID,
+		"service_name":   service.Name,
+		"service_status": service.Status,
+	}
+
+	if service.EscalationPolicy.ID != "" {
+		profile["escalation_policy_id"] = service.EscalationPolicy.ID
+		profile["escalation_levels"] = len(service.EscalationPolicy.EscalationRules)
+	}
+
+	if service.Teams != nil {
+		teams := make([]interface{}, 0, len(service.Teams))
+		for _, team := range service.Teams {
+			teams = append(teams, team.ID)
+		}
+
+		profile["service_teams"] = teams
+	}
+
+	resource, err := rs.NewGroupResource(
+		service.Name,
+		resourceTypeService,
+		service.ID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription(service.Description),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func (s *serviceResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
+	bag, cursor, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeService.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	paginationOpts := pagerduty.ListServiceOptions{
+		Limit:  ResourcesPageSize,
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
+		// include escalation policies so that the number of escalation levels is known without extra requests
+		Includes: []string{includeEscalationPolicies},
+	}
+
+	servicesResponse, err := s.client.ListServicesWithContext(ctx, paginationOpts)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list services: %w", err)
+	}
+
+	rv := make([]*v2.Resource, 0, len(servicesResponse.Services))
+	for _, service := range servicesResponse.Services {
+		sr, err := serviceResource(&service) // #nosec G601
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, sr)
+	}
+
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  servicesResponse.More,
+		Total: servicesResponse.Total,
+		Names: listedNames(servicesResponse.Services, func(service pagerduty.Service) string { return service.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, pageToken, rl.annotations(), nil
+}
+
+func (s *serviceResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	ownerEntitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeTeam),
+		ent.WithDisplayName(fmt.Sprintf("%s service %s", resource.DisplayName, serviceOwner)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty service %s", resource.DisplayName, serviceOwner)),
+	}
+
+	responderEntitlementOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(resourceTypeUser, resourceTypeEscalationPolicy),
+		ent.WithDisplayName(fmt.Sprintf("%s service %s", resource.DisplayName, serviceResponder)),
+		ent.WithDescription(fmt.Sprintf("%s PagerDuty service %s", resource.DisplayName, serviceResponder)),
+	}
+
+	rv = append(
+		rv,
+		ent.NewAssignmentEntitlement(resource, serviceOwner, ownerEntitlementOptions...),
+		ent.NewAssignmentEntitlement(resource, serviceResponder, responderEntitlementOptions...),
+	)
+
+	return rv, "", nil, nil
+}
+
+func (s *serviceResourceType) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	// parse resource profile to get owning teams and escalation policy of the service
+	groupTrait, err := rs.GetGroupTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	teams, ok := getProfileStringArray(groupTrait.Profile, "service_teams")
+	if !ok {
+		l.Info("pager-duty-connector: no teams found for service resource")
+	}
+
+	var rv []*v2.Grant
+	for _, t := range teams {
+		rv = append(rv, grant.NewGrant(
+			resource,
+			serviceOwner,
+			&v2.ResourceId{
+				ResourceType: resourceTypeTeam.Id,
+				Resource:     t,
+			},
+			grant.WithAnnotation(
+				&v2.GrantExpandable{
+					EntitlementIds: []string{fmt.Sprintf("team:%s:%s", t, roleMember)},
+				},
+			),
+		))
+	}
+
+	policyID, ok := rs.GetProfileStringValue(groupTrait.Profile, "escalation_policy_id")
+	if !ok {
+		l.Info("pager-duty-connector: no escalation policy found for service resource")
+		return rv, "", nil, nil
+	}
+
+	// everyone targeted by any level of the escalation policy can be paged for the service
+	levels, _ := rs.GetProfileInt64Value(groupTrait.Profile, "escalation_levels")
+	entitlementIDs := make([]string, 0, levels)
+	for level := 1; level <= int(levels); level++ {
+		entitlementIDs = append(entitlementIDs, fmt.Sprintf("escalation_policy:%s:%s", policyID, escalationLevelEntitlement(level)))
+	}
+
+	rv = append(rv, grant.NewGrant(
+		resource,
+		serviceResponder,
+		&v2.ResourceId{
+			ResourceType: resourceTypeEscalationPolicy.Id,
+			Resource:     policyID,
+		},
+		grant.WithAnnotation(
+			&v2.GrantExpandable{
+				EntitlementIds: entitlementIDs,
+			},
+		),
+	))
+
+	return rv, "", nil, nil
+}
+
+func serviceBuilder(client *pagerduty.Client) *serviceResourceType {
+	return &serviceResourceType{
+		resourceType: resourceTypeService,
+		client:       client,
+	}
+}
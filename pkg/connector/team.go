@@ -2,7 +2,11 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/PagerDuty/go-pagerduty"
@@ -14,6 +18,8 @@ import (
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -31,6 +37,9 @@ var teamAccessRoles = map[string]string{
 type teamResourceType struct {
 	resourceType *v2.ResourceType
 	client       *pagerduty.Client
+	apiEndpoint  string
+	// reassignmentTeam receives the objects of deleted teams, deleting a team owning objects is refused without it
+	reassignmentTeam string
 }
 
 func (t *teamResourceType) ResourceType(_ context.Context) *v2.ResourceType {
@@ -44,11 +53,16 @@ func teamResource(team *pagerduty.Team) (*v2.Resource, error) {
 		"team_name": team.Name,
 	}
 
+	if team.Parent != nil {
+		profile["parent_team_id"] = team.Parent.ID
+	}
+
 	resource, err := rs.NewGroupResource(
 		team.Name,
 		resourceTypeTeam,
 		team.ID,
 		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription(team.Description),
 	)
 	if err != nil {
 		return nil, err
@@ -58,6 +72,8 @@ func teamResource(team *pagerduty.Team) (*v2.Resource, error) {
 }
 
 func (t *teamResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx, rl := withRateLimitCapture(ctx)
+
 	// check if account has the ability to work with teams
 	err := t.client.TestAbilityWithContext(ctx, abilityTeams)
 	//nolint:nilerr // we want to return nil if the ability is not present
@@ -65,19 +81,16 @@ func (t *teamResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt
 		return nil, "", nil, nil
 	}
 
-	bag, page, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeTeam.Id})
+	bag, cursor, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeTeam.Id})
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	paginationOpts := pagerduty.ListTeamOptions{
 		Limit:  ResourcesPageSize,
-		Offset: page,
-	}
-
-	pageToken, err := handleNextPage(bag, page+ResourcesPageSize)
-	if err != nil {
-		return nil, "", nil, err
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
 	}
 
 	teamsResponse, err := t.client.ListTeamsWithContext(ctx, paginationOpts)
@@ -95,11 +108,16 @@ func (t *teamResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt
 		rv = append(rv, tr)
 	}
 
-	if teamsResponse.More {
-		return rv, pageToken, nil, nil
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  teamsResponse.More,
+		Total: teamsResponse.Total,
+		Names: listedNames(teamsResponse.Teams, func(team pagerduty.Team) string { return team.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	return rv, "", nil, nil
+	return rv, pageToken, rl.annotations(), nil
 }
 
 func (t *teamResourceType) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
@@ -130,19 +148,18 @@ func (t *teamResourceType) Entitlements(_ context.Context, resource *v2.Resource
 }
 
 func (t *teamResourceType) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	bag, page, err := parsePageToken(pToken.Token, resource.Id)
+	ctx, rl := withRateLimitCapture(ctx)
+
+	l := ctxzap.Extract(ctx)
+
+	bag, cursor, err := parsePageToken(pToken.Token, resource.Id)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	paginationOpts := pagerduty.ListTeamMembersOptions{
 		Limit:  ResourcesPageSize,
-		Offset: page,
-	}
-
-	pageToken, err := handleNextPage(bag, page+ResourcesPageSize)
-	if err != nil {
-		return nil, "", nil, err
+		Offset: cursor.Offset,
 	}
 
 	teamMembersResponse, err := t.client.ListTeamMembers(ctx, resource.Id.Resource, paginationOpts)
@@ -150,14 +167,10 @@ func (t *teamResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list team members: %w", err)
 	}
 
+	// team members already carry the user reference and team role, no need to fetch the users
 	var rv []*v2.Grant
 	for _, member := range teamMembersResponse.Members {
-		user, err := t.client.GetUserWithContext(ctx, member.User.ID, pagerduty.GetUserOptions{})
-		if err != nil {
-			return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list user: %w", err)
-		}
-
-		uID, err := rs.NewResourceID(resourceTypeUser, user.ID)
+		uID, err := rs.NewResourceID(resourceTypeUser, member.User.ID)
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -169,19 +182,32 @@ func (t *teamResourceType) Grants(ctx context.Context, resource *v2.Resource, pT
 			uID,
 		))
 
+		teamRole, ok := teamAccessRoles[member.Role]
+		if !ok {
+			l.Warn(
+				"pagerduty-connector: unknown team role",
+				zap.String("team_id", resource.Id.Resource),
+				zap.String("user_id", member.User.ID),
+				zap.String("role", member.Role),
+			)
+
+			continue
+		}
+
 		// Create also new grant for each team role the user has
 		rv = append(rv, grant.NewGrant(
 			resource,
-			teamAccessRoles[member.Role],
+			teamRole,
 			uID,
 		))
 	}
 
-	if teamMembersResponse.More {
-		return rv, pageToken, nil, nil
+	pageToken, err := handleNextPage(bag, cursor, listedPage{More: teamMembersResponse.More}, false)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	return rv, "", nil, nil
+	return rv, pageToken, rl.annotations(), nil
 }
 
 func (t *teamResourceType) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
@@ -198,21 +224,49 @@ func (t *teamResourceType) Grant(ctx context.Context, principal *v2.Resource, en
 	}
 
 	teamId, entitlementId := entitlement.Resource.Id.Resource, entitlement.Slug
+	userId := principal.Id.Resource
+
+	currentRole, isMember, err := t.memberRole(ctx, teamId, userId)
+	if err != nil {
+		return nil, err
+	}
 
 	var roleId pagerduty.TeamUserRole
 
-	if entitlementId != roleMember {
+	if entitlementId == roleMember {
+		// granting the membership must not reset the team role of an existing member
+		if isMember {
+			l.Info(
+				"pagerduty-connector: user is already a team member",
+				zap.String("team_id", teamId),
+				zap.String("principal_id", userId),
+			)
+
+			return nil, nil
+		}
+	} else {
 		// permission (role) entitlement also contains prefix `team-` which needs to be removed
 		entitlementId = strings.TrimPrefix(entitlementId, "team-")
 		roleId = pagerduty.TeamUserRole(entitlementId)
+
+		if isMember && currentRole == entitlementId {
+			l.Info(
+				"pagerduty-connector: team member already has the team role",
+				zap.String("team_id", teamId),
+				zap.String("principal_id", userId),
+				zap.String("role", entitlementId),
+			)
+
+			return nil, nil
+		}
 	}
 
-	// grant team membership
-	err := t.client.AddUserToTeamWithContext(
+	// grant team membership, for an existing member this changes its team role in place
+	err = t.client.AddUserToTeamWithContext(
 		ctx,
 		pagerduty.AddUserToTeamOptions{
 			TeamID: teamId,
-			UserID: principal.Id.Resource,
+			UserID: userId,
 			Role:   roleId,
 		},
 	)
@@ -239,24 +293,367 @@ func (t *teamResourceType) Revoke(ctx context.Context, grant *v2.Grant) (annotat
 		return nil, fmt.Errorf("pagerduty-connector: only users can have team membership revoked")
 	}
 
-	teamId := entitlement.Resource.Id.Resource
+	teamId, userId := entitlement.Resource.Id.Resource, principal.Id.Resource
+
+	if entitlement.Slug == roleMember {
+		// revoke team membership
+		err := t.client.RemoveUserFromTeamWithContext(
+			ctx,
+			teamId,
+			userId,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to revoke team membership: %w", err)
+		}
+
+		return nil, nil
+	}
+
+	// revoking a team role keeps the user on the team, so it is still paged for the team
+	roleId := strings.TrimPrefix(entitlement.Slug, "team-")
+
+	currentRole, isMember, err := t.memberRole(ctx, teamId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember || currentRole != roleId {
+		l.Info(
+			"pagerduty-connector: team member no longer has the team role",
+			zap.String("team_id", teamId),
+			zap.String("principal_id", userId),
+			zap.String("role", roleId),
+		)
 
-	// revoke team membership
-	err := t.client.RemoveUserFromTeamWithContext(
+		return nil, nil
+	}
+
+	downgradeRole, err := t.downgradeRole(ctx, teamId, roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.client.AddUserToTeamWithContext(
 		ctx,
-		teamId,
-		principal.Id.Resource,
+		pagerduty.AddUserToTeamOptions{
+			TeamID: teamId,
+			UserID: userId,
+			Role:   pagerduty.TeamUserRole(downgradeRole),
+		},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("pagerduty-connector: failed to revoke team membership: %w", err)
+		return nil, fmt.Errorf("pagerduty-connector: failed to revoke team role %s: %w", roleId, err)
+	}
+
+	return nil, nil
+}
+
+// teamRolesByRank orders the team roles from the least to the most privileged.
+var teamRolesByRank = []string{roleObserver, roleResponder, roleManager}
+
+// memberRole returns the team role of the user, and whether it is a member of the team at all.
+func (t *teamResourceType) memberRole(ctx context.Context, teamID string, userID string) (string, bool, error) {
+	opts := pagerduty.ListTeamMembersOptions{
+		Limit: ResourcesPageSize,
+	}
+
+	for {
+		teamMembersResponse, err := t.client.ListTeamMembers(ctx, teamID, opts)
+		if err != nil {
+			return "", false, fmt.Errorf("pagerduty-connector: failed to list team members: %w", err)
+		}
+
+		for _, member := range teamMembersResponse.Members {
+			if member.User.ID == userID {
+				return member.Role, true, nil
+			}
+		}
+
+		if !teamMembersResponse.More {
+			return "", false, nil
+		}
+
+		if err := nextOffset(&opts.Offset, "team member"); err != nil {
+			return "", false, err
+		}
+	}
+}
+
+// downgradeRole returns the team role replacing a revoked one: the default role of the team when it is less
+// privileged, or else the next less privileged team role.
+func (t *teamResourceType) downgradeRole(ctx context.Context, teamID string, revokedRole string) (string, error) {
+	revokedRank := slices.Index(teamRolesByRank, revokedRole)
+	if revokedRank <= 0 {
+		return "", status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: team role %s can't be downgraded, revoke the team membership instead",
+			revokedRole,
+		)
+	}
+
+	defaultRole, err := t.defaultRole(ctx, teamID)
+	if err != nil {
+		return "", err
+	}
+
+	if rank := slices.Index(teamRolesByRank, defaultRole); rank >= 0 && rank < revokedRank {
+		return defaultRole, nil
+	}
+
+	return teamRolesByRank[revokedRank-1], nil
+}
+
+// defaultRole returns the role PagerDuty gives new members of the team, "none" when the team has none.
+func (t *teamResourceType) defaultRole(ctx context.Context, teamID string) (string, error) {
+	u := fmt.Sprintf("%s/teams/%s", t.apiEndpoint, url.PathEscape(teamID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var teamResponse struct {
+		Team struct {
+			DefaultRole string `json:"default_role"`
+		} `json:"team"`
+	}
+
+	err = doAPIRequest(t.client, req, &teamResponse)
+	if err != nil {
+		return "", fmt.Errorf("pagerduty-connector: failed to get team: %w", err)
+	}
+
+	return teamResponse.Team.DefaultRole, nil
+}
+
+// findTeamByName returns the team with the name, or nil when there is none.
+func (t *teamResourceType) findTeamByName(ctx context.Context, name string) (*pagerduty.Team, error) {
+	teamsResponse, err := t.client.ListTeamsWithContext(ctx, pagerduty.ListTeamOptions{
+		Limit: ResourcesPageSize,
+		Query: name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to list teams: %w", err)
+	}
+
+	// the query also matches partial names
+	for _, team := range teamsResponse.Teams {
+		if team.Name == name {
+			return &team, nil
+		}
 	}
 
 	return nil, nil
 }
 
-func teamBuilder(client *pagerduty.Client) *teamResourceType {
+// Create creates a PagerDuty team, or returns the existing team with the same name.
+//
+// The group profile may set the parent_team_id of the team, and the manager_id of a user made its first manager.
+func (t *teamResourceType) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if resource.DisplayName == "" {
+		return nil, nil, status.Error(codes.InvalidArgument, "pagerduty-connector: a name is required to create a team")
+	}
+
+	var parentID, managerID string
+	if groupTrait, err := rs.GetGroupTrait(resource); err == nil {
+		parentID, _ = rs.GetProfileStringValue(groupTrait.Profile, "parent_team_id")
+		managerID, _ = rs.GetProfileStringValue(groupTrait.Profile, "manager_id")
+	}
+
+	team, err := t.findTeamByName(ctx, resource.DisplayName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if team != nil {
+		l.Info(
+			"pagerduty-connector: team with the name already exists",
+			zap.String("team_id", team.ID),
+		)
+	} else {
+		newTeam := &pagerduty.Team{
+			APIObject:   pagerduty.APIObject{Type: "team"},
+			Name:        resource.DisplayName,
+			Description: resource.Description,
+		}
+
+		if parentID != "" {
+			newTeam.Parent = &pagerduty.APIObject{
+				ID:   parentID,
+				Type: "team_reference",
+			}
+		}
+
+		team, err = t.client.CreateTeamWithContext(ctx, newTeam)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pagerduty-connector: failed to create team: %w", err)
+		}
+	}
+
+	// the manager is added to existing teams as well, completing a previous attempt that failed midway
+	if managerID != "" {
+		err = t.client.AddUserToTeamWithContext(ctx, pagerduty.AddUserToTeamOptions{
+			TeamID: team.ID,
+			UserID: managerID,
+			Role:   pagerduty.TeamUserRole(roleManager),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("pagerduty-connector: failed to add the manager to the team: %w", err)
+		}
+	}
+
+	tr, err := teamResource(team)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tr, nil, nil
+}
+
+// Delete deletes the team. Escalation policies, schedules and services of the team are moved to the
+// reassignment team, deleting a team that still owns any is refused when none is configured.
+func (t *teamResourceType) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if resourceId.ResourceType != resourceTypeTeam.Id {
+		return nil, fmt.Errorf("pagerduty-connector: only teams can be deleted by the team resource type")
+	}
+
+	teamID := resourceId.Resource
+
+	if t.reassignmentTeam == "" {
+		owned, err := t.ownedObjects(ctx, teamID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(owned) > 0 {
+			return nil, status.Errorf(
+				codes.FailedPrecondition,
+				"pagerduty-connector: cannot delete team %s, it still owns: %s",
+				teamID,
+				strings.Join(owned, "; "),
+			)
+		}
+	}
+
+	err := t.deleteTeam(ctx, teamID)
+	if err != nil {
+		var apiErr pagerduty.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			l.Info(
+				"pagerduty-connector: team is already deleted",
+				zap.String("team_id", teamID),
+			)
+
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("pagerduty-connector: failed to delete team: %w", err)
+	}
+
+	return nil, nil
+}
+
+// deleteTeam deletes the team, moving its objects to the reassignment team when one is configured.
+func (t *teamResourceType) deleteTeam(ctx context.Context, teamID string) error {
+	if t.reassignmentTeam == "" {
+		return t.client.DeleteTeamWithContext(ctx, teamID)
+	}
+
+	u := fmt.Sprintf("%s/teams/%s?reassignment_team=%s", t.apiEndpoint, url.PathEscape(teamID), url.QueryEscape(t.reassignmentTeam))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+
+	return doAPIRequest(t.client, req, nil)
+}
+
+// ownedObjects lists the escalation policies, schedules and services the team is assigned to.
+func (t *teamResourceType) ownedObjects(ctx context.Context, teamID string) ([]string, error) {
+	var rv []string
+
+	policiesOpts := pagerduty.ListEscalationPoliciesOptions{
+		Limit:   ResourcesPageSize,
+		TeamIDs: []string{teamID},
+	}
+	for {
+		policiesResponse, err := t.client.ListEscalationPoliciesWithContext(ctx, policiesOpts)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to list escalation policies: %w", err)
+		}
+
+		for _, policy := range policiesResponse.EscalationPolicies {
+			rv = append(rv, fmt.Sprintf("escalation policy %s", policy.Name))
+		}
+
+		if !policiesResponse.More {
+			break
+		}
+
+		if err := nextOffset(&policiesOpts.Offset, "escalation policy"); err != nil {
+			return nil, err
+		}
+	}
+
+	servicesOpts := pagerduty.ListServiceOptions{
+		Limit:   ResourcesPageSize,
+		TeamIDs: []string{teamID},
+	}
+	for {
+		servicesResponse, err := t.client.ListServicesWithContext(ctx, servicesOpts)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to list services: %w", err)
+		}
+
+		for _, service := range servicesResponse.Services {
+			rv = append(rv, fmt.Sprintf("service %s", service.Name))
+		}
+
+		if !servicesResponse.More {
+			break
+		}
+
+		if err := nextOffset(&servicesOpts.Offset, "service"); err != nil {
+			return nil, err
+		}
+	}
+
+	// schedules can't be filtered by team
+	schedulesOpts := pagerduty.ListSchedulesOptions{
+		Limit: ResourcesPageSize,
+	}
+	for {
+		schedulesResponse, err := t.client.ListSchedulesWithContext(ctx, schedulesOpts)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to list schedules: %w", err)
+		}
+
+		for _, schedule := range schedulesResponse.Schedules {
+			if slices.ContainsFunc(schedule.Teams, func(team pagerduty.APIObject) bool { return team.ID == teamID }) {
+				rv = append(rv, fmt.Sprintf("schedule %s", schedule.Name))
+			}
+		}
+
+		if !schedulesResponse.More {
+			break
+		}
+
+		if err := nextOffset(&schedulesOpts.Offset, "schedule"); err != nil {
+			return nil, err
+		}
+	}
+
+	return rv, nil
+}
+
+func teamBuilder(client *pagerduty.Client, apiEndpoint string, reassignmentTeam string) *teamResourceType {
 	return &teamResourceType{
-		resourceType: resourceTypeTeam,
-		client:       client,
+		resourceType:     resourceTypeTeam,
+		client:           client,
+		apiEndpoint:      apiEndpoint,
+		reassignmentTeam: reassignmentTeam,
 	}
 }
@@ -3,34 +3,123 @@ package connector
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/PagerDuty/go-pagerduty"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-sdk/pkg/helpers"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 type userResourceType struct {
 	resourceType *v2.ResourceType
 	client       *pagerduty.Client
+	apiEndpoint  string
+	users        *userCache
+	licenses     *licenseAllocations
+	// template holds the contact methods and notification rules added to created users
+	template *accountTemplate
 }
 
 func (u *userResourceType) ResourceType(_ context.Context) *v2.ResourceType {
 	return u.resourceType
 }
 
+// userStatus derives the status of the user from its invitation and, when the account has licenses, its license.
+func userStatus(user *pagerduty.User, license *pagerduty.LicenseAllocated, licensed bool) (v2.UserTrait_Status_Status, string) {
+	if user.InvitationSent {
+		return v2.UserTrait_Status_STATUS_DISABLED, "invitation not accepted"
+	}
+
+	if licensed && license == nil {
+		return v2.UserTrait_Status_STATUS_DISABLED, "no license allocated"
+	}
+
+	return v2.UserTrait_Status_STATUS_ENABLED, ""
+}
+
+// withDetailedStatus sets the status of the user along with the reason for it.
+func withDetailedStatus(status v2.UserTrait_Status_Status, details string) resource.UserTraitOption {
+	return func(ut *v2.UserTrait) error {
+		ut.Status = &v2.UserTrait_Status{
+			Status:  status,
+			Details: details,
+		}
+
+		return nil
+	}
+}
+
+// userContactMethods formats the contact methods of the user with their type and address.
+func userContactMethods(contactMethods []pagerduty.ContactMethod) []interface{} {
+	rv := make([]interface{}, 0, len(contactMethods))
+	for _, cm := range contactMethods {
+		address := cm.Address
+		// phone numbers are stored without their country code
+		if cm.CountryCode != 0 {
+			address = fmt.Sprintf("+%d %s", cm.CountryCode, cm.Address)
+		}
+
+		rv = append(rv, map[string]interface{}{
+			"type":    cm.Type,
+			"label":   cm.Label,
+			"address": address,
+		})
+	}
+
+	return rv
+}
+
+// userNotificationRules formats notification rules into human readable strings.
+func userNotificationRules(rules []pagerduty.NotificationRule) []interface{} {
+	rv := make([]interface{}, 0, len(rules))
+	for _, r := range rules {
+		rv = append(rv, fmt.Sprintf("%s urgency: %s after %dm", r.Urgency, r.ContactMethod.Type, r.StartDelayInMinutes))
+	}
+
+	return rv
+}
+
 // Create a new connector resource for a PagerDuty User.
-func userResource(user *pagerduty.User) (*v2.Resource, error) {
+func userResource(user *pagerduty.User, license *pagerduty.LicenseAllocated, licensed bool) (*v2.Resource, error) {
 	firstName, lastName := helpers.SplitFullName(user.Name)
 	profile := map[string]interface{}{
-		"first_name": firstName,
-		"last_name":  lastName,
-		"login":      user.Email,
-		"user_id":    user.ID,
+		"first_name":         firstName,
+		"last_name":          lastName,
+		"login":              user.Email,
+		"user_id":            user.ID,
+		"time_zone":          user.Timezone,
+		"job_title":          user.JobTitle,
+		"description":        user.Description,
+		"contact_methods":    userContactMethods(user.ContactMethods),
+		"notification_rules": userNotificationRules(user.NotificationRules),
+	}
+
+	if user.Teams != nil {
+		teams := make([]interface{}, 0, len(user.Teams))
+		for _, team := range user.Teams {
+			teams = append(teams, team.ID)
+		}
+
+		profile["user_teams"] = teams
+	}
+
+	if license != nil {
+		profile["license_id"] = license.ID
+		profile["license_name"] = license.Name
 	}
 
+	status, details := userStatus(user, license, licensed)
+
 	ret, err := resource.NewUserResource(
 		user.Name,
 		resourceTypeUser,
@@ -38,7 +127,7 @@ func userResource(user *pagerduty.User) (*v2.Resource, error) {
 		[]resource.UserTraitOption{
 			resource.WithEmail(user.Email, true),
 			resource.WithUserProfile(profile),
-			resource.WithStatus(v2.UserTrait_Status_STATUS_ENABLED),
+			withDetailedStatus(status, details),
 		},
 	)
 	if err != nil {
@@ -49,19 +138,28 @@ func userResource(user *pagerduty.User) (*v2.Resource, error) {
 }
 
 func (u *userResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	bag, page, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeUser.Id})
+	ctx, rl := withRateLimitCapture(ctx)
+
+	l := ctxzap.Extract(ctx)
+
+	// a new sync starts from the first page, drop users cached by the previous one
+	if pt.Token == "" {
+		u.users.reset()
+		u.licenses.reset()
+	}
+
+	bag, cursor, err := parsePageToken(pt.Token, &v2.ResourceId{ResourceType: resourceTypeUser.Id})
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	paginationOpts := pagerduty.ListUsersOptions{
 		Limit:  ResourcesPageSize,
-		Offset: page,
-	}
-
-	pageToken, err := handleNextPage(bag, page+ResourcesPageSize)
-	if err != nil {
-		return nil, "", nil, err
+		Offset: cursor.Offset,
+		Query:  cursor.Query,
+		Total:  cursor.wantsTotal(),
+		// side-load the profile details, so they don't need a request per user
+		Includes: []string{"contact_methods", "notification_rules", "teams"},
 	}
 
 	usersResponse, err := u.client.ListUsersWithContext(ctx, paginationOpts)
@@ -69,9 +167,22 @@ func (u *userResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt
 		return nil, "", nil, fmt.Errorf("pagerduty-connector: failed to list users: %w", err)
 	}
 
+	u.users.add(usersResponse.Users)
+
+	var licensesErr error
 	rv := make([]*v2.Resource, 0, len(usersResponse.Users))
 	for _, user := range usersResponse.Users {
-		ur, err := userResource(&user) // #nosec G601
+		var license *pagerduty.LicenseAllocated
+		licensed := false
+		// the license only adds detail to the user, users are synced without it when it can't be listed
+		if licensesErr == nil {
+			license, licensed, licensesErr = u.licenses.forUser(ctx, user.ID)
+			if licensesErr != nil {
+				l.Warn("pagerduty-connector: failed to get license allocations, syncing users without their license", zap.Error(licensesErr))
+			}
+		}
+
+		ur, err := userResource(&user, license, licensed) // #nosec G601
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -79,11 +190,21 @@ func (u *userResourceType) List(ctx context.Context, parentID *v2.ResourceId, pt
 		rv = append(rv, ur)
 	}
 
-	if usersResponse.More {
-		return rv, pageToken, nil, nil
+	pageToken, err := handleNextPage(bag, cursor, listedPage{
+		More:  usersResponse.More,
+		Total: usersResponse.Total,
+		Names: listedNames(usersResponse.Users, func(user pagerduty.User) string { return user.Name }),
+	}, true)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if pageToken == "" {
+		u.users.markComplete()
+		u.licenses.markComplete()
 	}
 
-	return rv, "", nil, nil
+	return rv, pageToken, rl.annotations(), nil
 }
 
 func (u *userResourceType) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
@@ -94,9 +215,256 @@ func (u *userResourceType) Grants(_ context.Context, _ *v2.Resource, _ *paginati
 	return nil, "", nil, nil
 }
 
-func userBuilder(client *pagerduty.Client) *userResourceType {
+// accountEmail returns the primary email of the account, falling back to its login.
+func accountEmail(accountInfo *v2.AccountInfo) string {
+	emails := accountInfo.GetEmails()
+	for _, email := range emails {
+		if email.GetIsPrimary() {
+			return email.GetAddress()
+		}
+	}
+
+	if len(emails) > 0 {
+		return emails[0].GetAddress()
+	}
+
+	return accountInfo.GetLogin()
+}
+
+// accountName returns the name of the account from its profile, either as a full name or first and last names.
+// Accounts without a name in their profile, like the ones created from the command line, are named after their
+// login, or the local part of their email when the login is an email.
+func accountName(accountInfo *v2.AccountInfo, email string) string {
+	profile := accountInfo.GetProfile()
+	if name, ok := resource.GetProfileStringValue(profile, "name"); ok && name != "" {
+		return name
+	}
+
+	firstName, _ := resource.GetProfileStringValue(profile, "first_name")
+	lastName, _ := resource.GetProfileStringValue(profile, "last_name")
+	if name := strings.TrimSpace(firstName + " " + lastName); name != "" {
+		return name
+	}
+
+	name := accountInfo.GetLogin()
+	if name == "" {
+		name = email
+	}
+
+	if local, _, ok := strings.Cut(name, "@"); ok {
+		return local
+	}
+
+	return name
+}
+
+// baseRoles are the base roles of PagerDuty users, licenses restrict which of them a user can have.
+var baseRoles = []string{
+	roleAdmin,
+	"limited_user",
+	"observer",
+	roleOwner,
+	"read_only_limited_user",
+	"read_only_user",
+	roleRestricted,
+	"user",
+}
+
+// CreatableRole reports whether a user can be given the base role, owners are only appointed
+// by transferring the ownership of the account.
+func CreatableRole(role string) bool {
+	return role != roleOwner && slices.Contains(baseRoles, role)
+}
+
+// findUserByEmail returns the user with the email, or nil when there is none.
+func (u *userResourceType) findUserByEmail(ctx context.Context, email string) (*pagerduty.User, error) {
+	usersResponse, err := u.client.ListUsersWithContext(ctx, pagerduty.ListUsersOptions{
+		Limit: ResourcesPageSize,
+		Query: email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to list users: %w", err)
+	}
+
+	// the query also matches names and partial emails
+	for _, user := range usersResponse.Users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// CreateAccount creates a PagerDuty user, or returns the existing user with the same email.
+//
+// The account profile may set the name (or first_name and last_name), time_zone, job_title, role,
+// license_id and team_id of the user.
+func (u *userResourceType) CreateAccount(
+	ctx context.Context,
+	accountInfo *v2.AccountInfo,
+	_ *v2.CredentialOptions,
+) (connectorbuilder.CreateAccountResponse, []*v2.PlaintextData, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	profile := accountInfo.GetProfile()
+
+	email := accountEmail(accountInfo)
+	if email == "" {
+		return nil, nil, nil, status.Error(codes.InvalidArgument, "pagerduty-connector: an email is required to create a user")
+	}
+
+	name := accountName(accountInfo, email)
+	if name == "" {
+		return nil, nil, nil, status.Error(codes.InvalidArgument, "pagerduty-connector: a name is required to create a user")
+	}
+
+	role, _ := resource.GetProfileStringValue(profile, "role")
+	if role != "" && !CreatableRole(role) {
+		return nil, nil, nil, status.Errorf(codes.InvalidArgument, "pagerduty-connector: role %s can't be given to a created user", role)
+	}
+
+	timezone, _ := resource.GetProfileStringValue(profile, "time_zone")
+	jobTitle, _ := resource.GetProfileStringValue(profile, "job_title")
+	licenseID, _ := resource.GetProfileStringValue(profile, "license_id")
+	teamID, _ := resource.GetProfileStringValue(profile, "team_id")
+
+	user, err := u.findUserByEmail(ctx, email)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if user != nil {
+		l.Info(
+			"pagerduty-connector: user with the email already exists",
+			zap.String("user_id", user.ID),
+		)
+	} else {
+		user, err = u.client.CreateUserWithContext(ctx, pagerduty.User{
+			APIObject: pagerduty.APIObject{Type: "user"},
+			Name:      name,
+			Email:     email,
+			Timezone:  timezone,
+			Role:      role,
+			JobTitle:  jobTitle,
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("pagerduty-connector: failed to create user: %w", err)
+		}
+
+		// only new users get the template, an existing user keeps the contact methods it has
+		if u.template != nil {
+			if err := u.applyAccountTemplate(ctx, user.ID, profile); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	// license and team are set on existing users as well, completing a previous attempt that failed midway
+	if licenseID != "" {
+		err = updateUserLicense(ctx, u.client, u.apiEndpoint, user.ID, licenseID, "")
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("pagerduty-connector: failed to set the license of the user: %w", err)
+		}
+	}
+
+	if teamID != "" {
+		err = u.client.AddUserToTeamWithContext(ctx, pagerduty.AddUserToTeamOptions{
+			TeamID: teamID,
+			UserID: user.ID,
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("pagerduty-connector: failed to add the user to team %s: %w", teamID, err)
+		}
+	}
+
+	ur, err := userResource(user, nil, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &v2.CreateAccountResponse_SuccessResult{
+		Resource:              ur,
+		IsCreateAccountResult: true,
+	}, nil, nil, nil
+}
+
+// applyAccountTemplate adds the contact methods and notification rules of the template to a created user.
+func (u *userResourceType) applyAccountTemplate(ctx context.Context, userID string, profile *structpb.Struct) error {
+	l := ctxzap.Extract(ctx)
+
+	// PagerDuty creates the login email contact method along with the user
+	contactMethodsResponse, err := u.client.ListUserContactMethodsWithContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("pagerduty-connector: failed to list user contact methods: %w", err)
+	}
+
+	contactMethods := make(map[string]pagerduty.ContactMethod)
+	for _, cm := range contactMethodsResponse.ContactMethods {
+		contactMethods[cm.Label] = cm
+	}
+
+	for _, t := range u.template.ContactMethods {
+		address, ok := resource.GetProfileStringValue(profile, t.AddressField)
+		if !ok || address == "" {
+			l.Warn(
+				"pagerduty-connector: account profile has no address for the contact method, skipping it",
+				zap.String("user_id", userID),
+				zap.String("contact_method", t.Label),
+				zap.String("address_field", t.AddressField),
+			)
+
+			continue
+		}
+
+		cm, err := u.client.CreateUserContactMethodWithContext(ctx, userID, pagerduty.ContactMethod{
+			Type:        t.Type,
+			Label:       t.Label,
+			Address:     address,
+			CountryCode: t.CountryCode,
+		})
+		if err != nil {
+			return fmt.Errorf("pagerduty-connector: failed to create contact method %s: %w", t.Label, err)
+		}
+
+		contactMethods[cm.Label] = *cm
+	}
+
+	for _, t := range u.template.NotificationRules {
+		cm, ok := contactMethods[t.ContactMethod]
+		if !ok {
+			l.Warn(
+				"pagerduty-connector: contact method of the notification rule was not created, skipping it",
+				zap.String("user_id", userID),
+				zap.String("contact_method", t.ContactMethod),
+			)
+
+			continue
+		}
+
+		_, err := u.client.CreateUserNotificationRuleWithContext(ctx, userID, pagerduty.NotificationRule{
+			Type:                "assignment_notification_rule",
+			Urgency:             t.Urgency,
+			StartDelayInMinutes: t.StartDelayInMinutes,
+			ContactMethod: pagerduty.ContactMethod{
+				ID:   cm.ID,
+				Type: cm.Type,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("pagerduty-connector: failed to create notification rule for contact method %s: %w", t.ContactMethod, err)
+		}
+	}
+
+	return nil
+}
+
+func userBuilder(client *pagerduty.Client, apiEndpoint string, users *userCache, licenses *licenseAllocations, template *accountTemplate) *userResourceType {
 	return &userResourceType{
 		resourceType: resourceTypeUser,
 		client:       client,
+		apiEndpoint:  apiEndpoint,
+		users:        users,
+		licenses:     licenses,
+		template:     template,
 	}
 }
@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// userCache keeps the users seen during a sync, so that syncers needing user details
+// don't have to fetch each user separately.
+type userCache struct {
+	client *pagerduty.Client
+
+	mu    sync.RWMutex
+	users map[string]*pagerduty.User
+	// listing is set while every user is being listed from the first page on
+	listing bool
+	// complete is set once every user of the account was listed during the current sync
+	complete bool
+	// byRole buckets the users by base role, it is built once the listing is complete
+	byRole map[string][]*pagerduty.User
+}
+
+func newUserCache(client *pagerduty.Client) *userCache {
+	return &userCache{
+		client: client,
+		users:  make(map[string]*pagerduty.User),
+	}
+}
+
+// reset drops every cached user, it is called when a listing of every user starts from the first page.
+func (c *userCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.users = make(map[string]*pagerduty.User)
+	c.listing = true
+	c.complete = false
+	c.byRole = nil
+}
+
+// markComplete records that every user was listed, so role membership can be answered from the cache. A listing
+// resumed from a page token of another process missed the first pages, it doesn't complete the cache.
+func (c *userCache) markComplete() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.listing {
+		return
+	}
+
+	c.listing = false
+	c.complete = true
+	c.byRole = nil
+}
+
+// usersWithRole returns the users having the base role, and false when the cache doesn't hold every user.
+func (c *userCache) usersWithRole(role string) ([]*pagerduty.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.complete {
+		return nil, false
+	}
+
+	// bucket all users in a single pass, every role resource is then answered from the buckets
+	if c.byRole == nil {
+		c.byRole = make(map[string][]*pagerduty.User)
+		for _, user := range c.users {
+			c.byRole[user.Role] = append(c.byRole[user.Role], user)
+		}
+	}
+
+	return c.byRole[role], true
+}
+
+// add stores listed users in the cache.
+func (c *userCache) add(users []pagerduty.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range users {
+		user := users[i]
+		c.users[user.ID] = &user
+	}
+
+	c.byRole = nil
+}
+
+// get returns the user from the cache, fetching it only when it wasn't listed yet.
+func (c *userCache) get(ctx context.Context, userID string) (*pagerduty.User, error) {
+	c.mu.RLock()
+	user, ok := c.users[userID]
+	c.mu.RUnlock()
+
+	if ok {
+		return user, nil
+	}
+
+	return c.refresh(ctx, userID)
+}
+
+// refresh fetches the current state of the user and updates the cache, provisioning always works on fresh data.
+func (c *userCache) refresh(ctx context.Context, userID string) (*pagerduty.User, error) {
+	user, err := c.client.GetUserWithContext(ctx, userID, pagerduty.GetUserOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to get user: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.users[user.ID] = user
+	// the role of the user may have changed
+	c.byRole = nil
+
+	return user, nil
+}
@@ -0,0 +1,30 @@
+package connector
+
+import (
+	"testing"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+func TestUserCacheCompletesFullListings(t *testing.T) {
+	c := newUserCache(nil)
+
+	// a listing resumed from another process' page token misses the first pages
+	c.add([]pagerduty.User{{APIObject: pagerduty.APIObject{ID: "P2"}, Role: "user"}})
+	c.markComplete()
+	if _, ok := c.usersWithRole("user"); ok {
+		t.Fatal("a resumed listing must not complete the cache")
+	}
+
+	c.reset()
+	c.add([]pagerduty.User{
+		{APIObject: pagerduty.APIObject{ID: "P1"}, Role: "admin"},
+		{APIObject: pagerduty.APIObject{ID: "P2"}, Role: "user"},
+	})
+	c.markComplete()
+
+	users, ok := c.usersWithRole("user")
+	if !ok || len(users) != 1 || users[0].ID != "P2" {
+		t.Fatalf("unexpected users %v, complete %v", users, ok)
+	}
+}
@@ -0,0 +1,286 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userObligations are the schedules and escalation policies a user has to be removed from before being deleted.
+type userObligations struct {
+	// schedules and policies no longer reference the user, they are ready to be pushed back to PagerDuty
+	schedules []*pagerduty.Schedule
+	policies  []*pagerduty.EscalationPolicy
+	// overrideSchedules list the user without it being in any of their layers, through an override
+	overrideSchedules []string
+	// blockers are the layers and levels the user is the only member of
+	blockers []string
+}
+
+func (u *userResourceType) Create(_ context.Context, _ *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	return nil, nil, status.Error(codes.Unimplemented, "pagerduty-connector: users are created through account provisioning")
+}
+
+// Delete removes the user from every schedule layer and escalation rule and deletes its overrides, before deleting it.
+// Nothing is changed when the user is the only member of a layer or the only target of an escalation rule.
+func (u *userResourceType) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if resourceId.ResourceType != resourceTypeUser.Id {
+		return nil, fmt.Errorf("pagerduty-connector: only users can be deleted by the user resource type")
+	}
+
+	userID := resourceId.Resource
+
+	_, err := u.client.GetUserWithContext(ctx, userID, pagerduty.GetUserOptions{})
+	if err != nil {
+		var apiErr pagerduty.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			l.Info(
+				"pagerduty-connector: user is already deleted",
+				zap.String("user_id", userID),
+			)
+
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("pagerduty-connector: failed to get user: %w", err)
+	}
+
+	obligations := &userObligations{}
+	err = u.collectScheduleObligations(ctx, userID, obligations)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.collectEscalationObligations(ctx, userID, obligations)
+	if err != nil {
+		return nil, err
+	}
+
+	// check everything before changing anything, so that a refused deletion leaves no gap in coverage
+	if len(obligations.blockers) > 0 {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"pagerduty-connector: cannot delete user %s, removing it would leave empty: %s",
+			userID,
+			strings.Join(obligations.blockers, "; "),
+		)
+	}
+
+	err = u.deleteOverrides(ctx, userID, obligations)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range obligations.schedules {
+		err = updateScheduleLayers(ctx, u.client, schedule)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to remove user from schedule %s: %w", schedule.Name, err)
+		}
+	}
+
+	for _, policy := range obligations.policies {
+		_, err = u.client.UpdateEscalationPolicyWithContext(ctx, policy.ID, *policy)
+		if err != nil {
+			return nil, fmt.Errorf("pagerduty-connector: failed to remove user from escalation policy %s: %w", policy.Name, err)
+		}
+	}
+
+	err = u.client.DeleteUserWithContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("pagerduty-connector: failed to delete user: %w", err)
+	}
+
+	return nil, nil
+}
+
+// collectScheduleObligations finds the schedule layers of the user and removes it from their rotation.
+func (u *userResourceType) collectScheduleObligations(ctx context.Context, userID string, obligations *userObligations) error {
+	opts := pagerduty.ListSchedulesOptions{
+		Limit: ResourcesPageSize,
+	}
+
+	for {
+		schedulesResponse, err := u.client.ListSchedulesWithContext(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("pagerduty-connector: failed to list schedules: %w", err)
+		}
+
+		for _, s := range schedulesResponse.Schedules {
+			if !slices.ContainsFunc(s.Users, func(user pagerduty.APIObject) bool { return user.ID == userID }) {
+				continue
+			}
+
+			schedule, err := u.client.GetScheduleWithContext(ctx, s.ID, pagerduty.GetScheduleOptions{})
+			if err != nil {
+				return fmt.Errorf("pagerduty-connector: failed to get schedule: %w", err)
+			}
+
+			changed := false
+			for i, layer := range schedule.ScheduleLayers {
+				users := slices.DeleteFunc(slices.Clone(layer.Users), func(ur pagerduty.UserReference) bool { return ur.User.ID == userID })
+				if len(users) == len(layer.Users) {
+					continue
+				}
+
+				// PagerDuty rejects schedule layers without any user
+				if len(users) == 0 {
+					obligations.blockers = append(obligations.blockers, fmt.Sprintf("schedule %s layer %s", schedule.Name, layer.Name))
+				}
+
+				schedule.ScheduleLayers[i].Users = users
+				changed = true
+			}
+
+			// the schedule may list the user through an override only
+			if changed {
+				obligations.schedules = append(obligations.schedules, schedule)
+			} else {
+				obligations.overrideSchedules = append(obligations.overrideSchedules, schedule.ID)
+			}
+		}
+
+		if !schedulesResponse.More {
+			return nil
+		}
+
+		if err := nextOffset(&opts.Offset, "schedule"); err != nil {
+			return err
+		}
+	}
+}
+
+// collectEscalationObligations finds the escalation rules targeting the user and removes it from their targets.
+func (u *userResourceType) collectEscalationObligations(ctx context.Context, userID string, obligations *userObligations) error {
+	opts := pagerduty.ListEscalationPoliciesOptions{
+		Limit:   ResourcesPageSize,
+		UserIDs: []string{userID},
+	}
+
+	for {
+		policiesResponse, err := u.client.ListEscalationPoliciesWithContext(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("pagerduty-connector: failed to list escalation policies: %w", err)
+		}
+
+		for _, policy := range policiesResponse.EscalationPolicies {
+			changed := false
+			for i, rule := range policy.EscalationRules {
+				targets := slices.DeleteFunc(slices.Clone(rule.Targets), func(target pagerduty.APIObject) bool {
+					return target.ID == userID && target.Type == userReference
+				})
+				if len(targets) == len(rule.Targets) {
+					continue
+				}
+
+				// PagerDuty rejects escalation rules without any target
+				if len(targets) == 0 {
+					obligations.blockers = append(obligations.blockers, fmt.Sprintf("escalation policy %s level %d", policy.Name, i+1))
+				}
+
+				policy.EscalationRules[i].Targets = targets
+				changed = true
+			}
+
+			// the policy may reference the user through a schedule only
+			if changed {
+				obligations.policies = append(obligations.policies, &policy) // #nosec G601
+			}
+		}
+
+		if !policiesResponse.More {
+			return nil
+		}
+
+		if err := nextOffset(&opts.Offset, "escalation policy"); err != nil {
+			return err
+		}
+	}
+}
+
+// deleteOverrides deletes the active and future overrides of the user, on its schedules and on any schedule it is
+// on-call for through an override.
+func (u *userResourceType) deleteOverrides(ctx context.Context, userID string, obligations *userObligations) error {
+	l := ctxzap.Extract(ctx)
+
+	// Only UTC format is supported by PagerDuty
+	now := time.Now().UTC()
+	since, until := now.Format(time.RFC3339), now.Add(overrideRevokeWindow).Format(time.RFC3339)
+
+	scheduleIDs := slices.Clone(obligations.overrideSchedules)
+	for _, schedule := range obligations.schedules {
+		scheduleIDs = append(scheduleIDs, schedule.ID)
+	}
+
+	opts := pagerduty.ListOnCallOptions{
+		Limit:   ResourcesPageSize,
+		UserIDs: []string{userID},
+		Since:   since,
+		Until:   until,
+	}
+
+	for {
+		onCallsResponse, err := u.client.ListOnCallsWithContext(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("pagerduty-connector: failed to list on-calls: %w", err)
+		}
+
+		for _, onCall := range onCallsResponse.OnCalls {
+			if onCall.Schedule.ID != "" && !slices.Contains(scheduleIDs, onCall.Schedule.ID) {
+				scheduleIDs = append(scheduleIDs, onCall.Schedule.ID)
+			}
+		}
+
+		if !onCallsResponse.More {
+			break
+		}
+
+		if err := nextOffset(&opts.Offset, "on-call"); err != nil {
+			return err
+		}
+	}
+
+	for _, scheduleID := range scheduleIDs {
+		overridesResponse, err := u.client.ListOverridesWithContext(ctx, scheduleID, pagerduty.ListOverridesOptions{
+			Since: since,
+			Until: until,
+		})
+		if err != nil {
+			return fmt.Errorf("pagerduty-connector: failed to list schedule overrides: %w", err)
+		}
+
+		for _, override := range overridesResponse.Overrides {
+			if override.User.ID != userID {
+				continue
+			}
+
+			// deleting an active override truncates it to end now
+			err = u.client.DeleteOverrideWithContext(ctx, scheduleID, override.ID)
+			if err != nil {
+				return fmt.Errorf("pagerduty-connector: failed to delete schedule override: %w", err)
+			}
+
+			l.Info(
+				"pagerduty-connector: deleted schedule override of deleted user",
+				zap.String("user_id", userID),
+				zap.String("schedule_id", scheduleID),
+				zap.String("override_id", override.ID),
+			)
+		}
+	}
+
+	return nil
+}